@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -24,12 +25,47 @@ func main() {
 	}
 	db := database.Connect()
 	fmt.Println("Migrating...")
-	err = db.AutoMigrate(&entity.Channel{}, &entity.Friendship{}, &entity.Media{}, &entity.Message{}, &entity.Reaction{}, &entity.Server{}, &entity.User{}, &entity.BlacklistedToken{})
+	err = db.SetupJoinTable(&entity.User{}, "Servers", &entity.UserServer{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&entity.Channel{}, &entity.Friendship{}, &entity.Media{}, &entity.Message{}, &entity.Reaction{}, &entity.Server{}, &entity.User{}, &entity.BlacklistedToken{}, &entity.Role{}, &entity.MemberRole{}, &entity.ChannelOverwrite{}, &entity.Invite{}, &entity.Ban{}, &entity.AuditLogEntry{}, &entity.MessageRevision{}, &entity.Thread{}, &entity.Mention{}, &entity.UserMention{}, &entity.MessageTerm{}, &entity.ReadState{})
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println("Migration successful!")
 
+	// Instances behind a load balancer share their WebSocket events through Redis,
+	// and share a search index stored in the database since their memory indexes
+	// would each miss messages
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		broker, err := ws.NewRedisBroker(redisURL)
+		if err != nil {
+			log.Fatal("Error connecting to Redis ", err.Error())
+		}
+		if err := ws.SetBroker(broker); err != nil {
+			log.Fatal("Error subscribing to Redis ", err.Error())
+		}
+		fmt.Println("Using the Redis broker")
+		searchIndex := services.NewDatabaseSearchIndex(db)
+		services.SetSearchIndex(searchIndex)
+		go func() {
+			if err := searchIndex.IndexMissing(); err != nil {
+				log.Println("Failed to index messages:", err)
+			}
+		}()
+	} else {
+		// Searches only find the messages indexed so far until the rebuild is done
+		go func() {
+			fmt.Println("Indexing messages...")
+			if err := services.NewSearchService(db).RebuildIndex(); err != nil {
+				log.Println("Failed to build the search index:", err)
+				return
+			}
+			fmt.Println("Messages indexed")
+		}()
+	}
+
 	r := mux.NewRouter()
 
 	// Serve static files from uploads directory
@@ -43,30 +79,79 @@ func main() {
 	r.HandleFunc("/get-user", services.GetUser).Methods("GET")
 	r.HandleFunc("/ws", ws.HandleWebSocket(db)).Methods("GET")
 
-	userController := controllers.NewUserController(services.NewUserService(db))
+	permissionService := services.NewPermissionService(db)
+	auditLogService := services.NewAuditLogService(db)
+	readStateService := services.NewReadStateService(db)
+
+	// Initialize friend controller
+	friendController := controllers.NewFriendController(services.NewFriendshipService(db))
+
+	// Friend routes, registered before the user routes so that @me is not read as a user ID
+	r.HandleFunc("/users/@me/friends", services.AuthMiddleware(friendController.GetFriends)).Methods("GET")
+	r.HandleFunc("/users/@me/friends/{userId}", services.AuthMiddleware(friendController.RemoveFriend)).Methods("DELETE")
+	r.HandleFunc("/users/@me/friend-requests", services.AuthMiddleware(friendController.GetFriendRequests)).Methods("GET")
+	r.HandleFunc("/users/@me/friend-requests", services.AuthMiddleware(friendController.SendFriendRequest)).Methods("POST")
+	r.HandleFunc("/users/@me/friend-requests/{userId}", services.AuthMiddleware(friendController.CancelFriendRequest)).Methods("DELETE")
+	r.HandleFunc("/users/@me/friend-requests/{userId}/accept", services.AuthMiddleware(friendController.AcceptFriendRequest)).Methods("POST")
+	r.HandleFunc("/users/@me/friend-requests/{userId}/decline", services.AuthMiddleware(friendController.DeclineFriendRequest)).Methods("POST")
+	r.HandleFunc("/users/@me/blocks", services.AuthMiddleware(friendController.GetBlockedUsers)).Methods("GET")
+	r.HandleFunc("/users/@me/blocks/{userId}", services.AuthMiddleware(friendController.BlockUser)).Methods("PUT")
+	r.HandleFunc("/users/@me/blocks/{userId}", services.AuthMiddleware(friendController.UnblockUser)).Methods("DELETE")
+
+	userController := controllers.NewUserController(services.NewUserService(db), services.NewMentionService(db), services.NewFriendshipService(db))
 
 	// User routes
+	r.HandleFunc("/users/@me/mentions", services.AuthMiddleware(userController.GetMyMentions)).Methods("GET")
+	r.HandleFunc("/users/@me/profile", services.AuthMiddleware(userController.UpdateProfile)).Methods("PATCH")
+	r.HandleFunc("/users/@me/avatar", services.AuthMiddleware(userController.UploadAvatar)).Methods("PUT")
+	r.HandleFunc("/users/@me/banner", services.AuthMiddleware(userController.UploadBanner)).Methods("PUT")
+	r.HandleFunc("/users/@me/custom-status", services.AuthMiddleware(userController.SetCustomStatus)).Methods("PUT")
+	r.HandleFunc("/users/@me/custom-status", services.AuthMiddleware(userController.ClearCustomStatus)).Methods("DELETE")
 	r.HandleFunc("/users", services.AuthMiddleware(userController.GetUsers)).Methods("GET")
 	r.HandleFunc("/users/{id}", services.AuthMiddleware(userController.GetUser)).Methods("GET")
 	r.HandleFunc("/users/{id}", services.AuthMiddleware(userController.UpdateUser)).Methods("PUT")
 	r.HandleFunc("/users/{id}", services.AuthMiddleware(userController.DeleteUser)).Methods("DELETE")
 	r.HandleFunc("/users/{id}/friends", services.AuthMiddleware(userController.GetUserFriends)).Methods("GET")
+	r.HandleFunc("/users/{id}/profile", services.AuthMiddleware(userController.GetUserProfile)).Methods("GET")
 
 	// Initialize message controller
-	messageController := controllers.NewMessageController(services.NewMessageService(db))
+	messageController := controllers.NewMessageController(services.NewMessageService(db), services.NewThreadService(db), services.NewChannelService(db), services.NewFriendshipService(db), permissionService, auditLogService)
 
 	// Message routes
 	r.HandleFunc("/channels/{channelID}/messages", services.AuthMiddleware(messageController.GetChannelMessages)).Methods("GET")
+	r.HandleFunc("/channels/{id}/pins", services.AuthMiddleware(messageController.GetChannelPins)).Methods("GET")
+	r.HandleFunc("/channels/{id}/pins/{messageId}", services.AuthMiddleware(messageController.PinMessage)).Methods("PUT")
+	r.HandleFunc("/channels/{id}/pins/{messageId}", services.AuthMiddleware(messageController.UnpinMessage)).Methods("DELETE")
 	r.HandleFunc("/messages", services.AuthMiddleware(messageController.CreateMessage)).Methods("POST")
 	r.HandleFunc("/messages/{id}", services.AuthMiddleware(messageController.GetMessage)).Methods("GET")
-	r.HandleFunc("/messages/{id}/pin", services.AuthMiddleware(messageController.PinMessage)).Methods("GET")
-	r.HandleFunc("/messages/{id}/unpin", services.AuthMiddleware(messageController.UnpinMessage)).Methods("GET")
+	r.HandleFunc("/messages/{id}", services.AuthMiddleware(messageController.EditMessage)).Methods("PATCH")
+	r.HandleFunc("/messages/{id}", services.AuthMiddleware(messageController.DeleteMessage)).Methods("DELETE")
+	r.HandleFunc("/messages/{id}/revisions", services.AuthMiddleware(messageController.GetMessageRevisions)).Methods("GET")
 	r.HandleFunc("/messages/{id}/reactions", services.AuthMiddleware(messageController.AddReaction)).Methods("POST")
 	r.HandleFunc("/messages/{id}/reactions/{reactionId}", services.AuthMiddleware(messageController.RemoveReaction)).Methods("DELETE")
 	r.HandleFunc("/messages/{id}/media", services.AuthMiddleware(messageController.AddMedia)).Methods("POST")
 
+	// Initialize search controller
+	searchController := controllers.NewSearchController(services.NewSearchService(db), permissionService)
+
+	// Search routes
+	r.HandleFunc("/servers/{id}/messages/search", services.AuthMiddleware(searchController.SearchServerMessages)).Methods("GET")
+
+	// Initialize thread controller
+	threadController := controllers.NewThreadController(services.NewThreadService(db), services.NewMessageService(db), permissionService)
+
+	// Thread routes
+	r.HandleFunc("/messages/{id}/threads", services.AuthMiddleware(threadController.CreateThread)).Methods("POST")
+	r.HandleFunc("/channels/{channelID}/threads", services.AuthMiddleware(threadController.GetChannelThreads)).Methods("GET")
+	r.HandleFunc("/threads/{id}", services.AuthMiddleware(threadController.GetThread)).Methods("GET")
+	r.HandleFunc("/threads/{id}", services.AuthMiddleware(threadController.UpdateThread)).Methods("PATCH")
+	r.HandleFunc("/threads/{id}/messages", services.AuthMiddleware(threadController.GetThreadMessages)).Methods("GET")
+	r.HandleFunc("/threads/{id}/members", services.AuthMiddleware(threadController.GetThreadMembers)).Methods("GET")
+	r.HandleFunc("/threads/{id}/members/@me", services.AuthMiddleware(threadController.JoinThread)).Methods("PUT")
+	r.HandleFunc("/threads/{id}/members/@me", services.AuthMiddleware(threadController.LeaveThread)).Methods("DELETE")
+
 	// Initialize channel controller
-	channelController := controllers.NewChannelController(services.NewChannelService(db), services.NewServerService(db))
+	channelController := controllers.NewChannelController(services.NewChannelService(db), services.NewServerService(db), permissionService, auditLogService, readStateService)
 
 	// Channel routes
 	r.HandleFunc("/channels", services.AuthMiddleware(channelController.GetChannels)).Methods("GET")
@@ -75,9 +160,15 @@ func main() {
 	r.HandleFunc("/channels/{id}", services.AuthMiddleware(channelController.UpdateChannel)).Methods("PUT")
 	r.HandleFunc("/channels/{id}", services.AuthMiddleware(channelController.DeleteChannel)).Methods("DELETE")
 	r.HandleFunc("/servers/{id}/channels", services.AuthMiddleware(channelController.GetServerChannels)).Methods("GET")
+	r.HandleFunc("/channels/{id}/overwrites", services.AuthMiddleware(channelController.GetChannelOverwrites)).Methods("GET")
+	r.HandleFunc("/channels/{id}/overwrites", services.AuthMiddleware(channelController.SetChannelOverwrite)).Methods("PUT")
+	r.HandleFunc("/channels/{id}/overwrites/{targetType}/{targetId}", services.AuthMiddleware(channelController.DeleteChannelOverwrite)).Methods("DELETE")
+	r.HandleFunc("/channels/{id}/ack", services.AuthMiddleware(channelController.AckChannel)).Methods("POST")
+	r.HandleFunc("/users/@me/channels", services.AuthMiddleware(channelController.GetDirectChannels)).Methods("GET")
+	r.HandleFunc("/users/@me/channels", services.AuthMiddleware(channelController.OpenDirectChannel)).Methods("POST")
 
 	// Initialize server controller
-	serverController := controllers.NewServerController(services.NewServerService(db), services.NewChannelService(db))
+	serverController := controllers.NewServerController(services.NewServerService(db), services.NewChannelService(db), permissionService, auditLogService, readStateService)
 
 	// Server routes
 	r.HandleFunc("/servers", services.AuthMiddleware(serverController.GetUserServers)).Methods("GET")
@@ -86,12 +177,51 @@ func main() {
 	r.HandleFunc("/servers/{id}", services.AuthMiddleware(serverController.UpdateServer)).Methods("PUT")
 	r.HandleFunc("/servers/{id}", services.AuthMiddleware(serverController.DeleteServer)).Methods("DELETE")
 	r.HandleFunc("/servers/{id}/add-user", services.AuthMiddleware(serverController.AddUserToServerByEmail)).Methods("POST")
+	r.HandleFunc("/servers/{id}/transfer-ownership", services.AuthMiddleware(serverController.TransferOwnership)).Methods("POST")
+	r.HandleFunc("/servers/{id}/leave", services.AuthMiddleware(serverController.LeaveServer)).Methods("POST")
+	r.HandleFunc("/servers/{id}/members/@me/nickname", services.AuthMiddleware(serverController.SetNickname)).Methods("PUT")
+
+	// Initialize role controller
+	roleController := controllers.NewRoleController(services.NewRoleService(db), permissionService, auditLogService)
+
+	// Role routes
+	r.HandleFunc("/servers/{id}/roles", services.AuthMiddleware(roleController.GetServerRoles)).Methods("GET")
+	r.HandleFunc("/servers/{id}/roles", services.AuthMiddleware(roleController.CreateRole)).Methods("POST")
+	r.HandleFunc("/servers/{id}/roles/{roleId}", services.AuthMiddleware(roleController.UpdateRole)).Methods("PUT")
+	r.HandleFunc("/servers/{id}/roles/{roleId}", services.AuthMiddleware(roleController.DeleteRole)).Methods("DELETE")
+	r.HandleFunc("/servers/{id}/members/{userId}/roles/{roleId}", services.AuthMiddleware(roleController.AssignRole)).Methods("PUT")
+	r.HandleFunc("/servers/{id}/members/{userId}/roles/{roleId}", services.AuthMiddleware(roleController.RemoveRole)).Methods("DELETE")
+
+	// Initialize invite controller
+	inviteController := controllers.NewInviteController(services.NewInviteService(db), permissionService, auditLogService)
+
+	// Invite routes
+	r.HandleFunc("/servers/{id}/invites", services.AuthMiddleware(inviteController.GetServerInvites)).Methods("GET")
+	r.HandleFunc("/servers/{id}/invites", services.AuthMiddleware(inviteController.CreateInvite)).Methods("POST")
+	r.HandleFunc("/invites/{code}", services.AuthMiddleware(inviteController.RevokeInvite)).Methods("DELETE")
+	r.HandleFunc("/invites/{code}/accept", services.AuthMiddleware(inviteController.AcceptInvite)).Methods("POST")
+
+	// Initialize moderation controller
+	moderationController := controllers.NewModerationController(services.NewModerationService(db), services.NewChannelService(db), permissionService, auditLogService)
+
+	// Moderation routes
+	r.HandleFunc("/servers/{id}/members/{userId}", services.AuthMiddleware(moderationController.KickMember)).Methods("DELETE")
+	r.HandleFunc("/servers/{id}/members/{userId}/timeout", services.AuthMiddleware(moderationController.TimeoutMember)).Methods("PUT")
+	r.HandleFunc("/servers/{id}/bans", services.AuthMiddleware(moderationController.GetServerBans)).Methods("GET")
+	r.HandleFunc("/servers/{id}/bans/{userId}", services.AuthMiddleware(moderationController.BanMember)).Methods("PUT")
+	r.HandleFunc("/servers/{id}/bans/{userId}", services.AuthMiddleware(moderationController.UnbanMember)).Methods("DELETE")
+
+	// Initialize audit log controller
+	auditLogController := controllers.NewAuditLogController(auditLogService, permissionService)
+
+	// Audit log routes
+	r.HandleFunc("/servers/{id}/audit-log", services.AuthMiddleware(auditLogController.GetServerAuditLog)).Methods("GET")
 
 	// Setup CORS options
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:5174"}, // your frontend URL
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Audit-Log-Reason"},
 		AllowCredentials: true,
 	})
 
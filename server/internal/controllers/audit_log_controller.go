@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+	"lesha.com/server/internal/services"
+)
+
+type AuditLogController struct {
+	auditLogService   *services.AuditLogService
+	permissionService *services.PermissionService
+}
+
+func NewAuditLogController(auditLogService *services.AuditLogService, permissionService *services.PermissionService) *AuditLogController {
+	return &AuditLogController{
+		auditLogService:   auditLogService,
+		permissionService: permissionService,
+	}
+}
+
+// GetServerAuditLog returns the audit log of a server, newest first.
+// It can be filtered with the actor_id and action query parameters and
+// paginated with before (the ID of the oldest entry already fetched) and limit.
+func (c *AuditLogController) GetServerAuditLog(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := repositories.AuditLogFilter{
+		Action: query.Get("action"),
+	}
+	if actorId := query.Get("actor_id"); actorId != "" {
+		id, err := strconv.ParseUint(actorId, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid actor ID", http.StatusBadRequest)
+			return
+		}
+		filter.ActorID = uint(id)
+	}
+	if before := query.Get("before"); before != "" {
+		id, err := strconv.ParseUint(before, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		filter.Before = uint(id)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	allowed, err := c.permissionService.HasServerPermission(uint(serverId), user.ID, entity.PermissionViewAuditLog)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to view the audit log", http.StatusForbidden)
+		return
+	}
+
+	entries, err := c.auditLogService.GetServerEntries(uint(serverId), filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// auditLogReason returns the reason given by the moderator for an action
+func auditLogReason(r *http.Request) string {
+	return r.Header.Get("X-Audit-Log-Reason")
+}
@@ -9,35 +9,76 @@ import (
 	"github.com/gorilla/mux"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
 )
 
 type ChannelController struct {
-	channelService *services.ChannelService
-	serverService  *services.ServerService
+	channelService    *services.ChannelService
+	serverService     *services.ServerService
+	permissionService *services.PermissionService
+	auditLogService   *services.AuditLogService
+	readStateService  *services.ReadStateService
 }
 
-func NewChannelController(channelService *services.ChannelService, serverService *services.ServerService) *ChannelController {
+func NewChannelController(channelService *services.ChannelService, serverService *services.ServerService, permissionService *services.PermissionService, auditLogService *services.AuditLogService, readStateService *services.ReadStateService) *ChannelController {
 	return &ChannelController{
-		channelService: channelService,
-		serverService:  serverService,
+		channelService:    channelService,
+		serverService:     serverService,
+		permissionService: permissionService,
+		auditLogService:   auditLogService,
+		readStateService:  readStateService,
 	}
 }
 
-// GetChannels returns all channels
+// GetChannels returns the channels the user can view across all servers
 func (c *ChannelController) GetChannels(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	channels, err := c.channelService.GetChannels()
 	if err != nil {
 		http.Error(w, "Failed to fetch channels", http.StatusInternalServerError)
 		return
 	}
 
+	// Only return the channels the user can view
+	visibleChannels := make([]entity.Channel, 0, len(channels))
+	for _, channel := range channels {
+		// Channels of deleted servers fail the check and are skipped
+		allowed, err := c.permissionService.CanAccessChannel(channel.ID, user.ID)
+		if err == nil && allowed {
+			visibleChannels = append(visibleChannels, channel)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(channels)
+	json.NewEncoder(w).Encode(visibleChannels)
 }
 
 // GetChannel returns a specific channel by ID
 func (c *ChannelController) GetChannel(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	channelIdStr := vars["id"]
 
@@ -53,6 +94,12 @@ func (c *ChannelController) GetChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	allowed, err := c.permissionService.CanAccessChannel(channel.ID, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(channel)
@@ -61,16 +108,32 @@ func (c *ChannelController) GetChannel(w http.ResponseWriter, r *http.Request) {
 // CreateChannel creates a new channel
 func (c *ChannelController) CreateChannel(w http.ResponseWriter, r *http.Request) {
 	var channel entity.Channel
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	serverID, err := strconv.ParseUint(r.FormValue("serverID"), 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid server ID", http.StatusBadRequest)
 		return
 	}
-	channel.ServerID = uint(serverID)
+	serverIDUint := uint(serverID)
+	channel.ServerID = &serverIDUint
 	channel.Name = r.FormValue("name")
 
-	// Log channel information for debugging
-	fmt.Printf("Creating channel: Name=%s, ServerID=%d\n", channel.Name, channel.ServerID)
+	allowed, err := c.permissionService.HasServerPermission(channel.GetServerID(), user.ID, entity.PermissionManageChannels)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to manage channels", http.StatusForbidden)
+		return
+	}
 
 	if err := c.channelService.CreateChannel(&channel); err != nil {
 		http.Error(w, "Failed to create channel", http.StatusInternalServerError)
@@ -91,6 +154,19 @@ func (c *ChannelController) CreateChannel(w http.ResponseWriter, r *http.Request
 			return
 		}
 	}
+	ws.BroadcastChannelCreate(c.channelService.DB, &channel)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", nil, channel.Name)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   channel.GetServerID(),
+		ActorID:    user.ID,
+		Action:     entity.AuditChannelCreate,
+		TargetType: entity.AuditTargetChannel,
+		TargetID:   channel.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -99,6 +175,18 @@ func (c *ChannelController) CreateChannel(w http.ResponseWriter, r *http.Request
 
 // UpdateChannel updates a channel's information
 func (c *ChannelController) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	channelIdStr := vars["id"]
 
@@ -120,7 +208,15 @@ func (c *ChannelController) UpdateChannel(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	allowed, err := c.permissionService.HasServerPermission(channel.GetServerID(), user.ID, entity.PermissionManageChannels)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to manage channels", http.StatusForbidden)
+		return
+	}
+
+	changes := entity.AuditLogChanges{}
 	if updateData.Name != "" {
+		changes.Set("name", channel.Name, updateData.Name)
 		channel.Name = updateData.Name
 	}
 
@@ -128,6 +224,17 @@ func (c *ChannelController) UpdateChannel(w http.ResponseWriter, r *http.Request
 		http.Error(w, "Failed to update channel", http.StatusInternalServerError)
 		return
 	}
+	ws.BroadcastChannelUpdate(c.channelService.DB, channel)
+
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   channel.GetServerID(),
+		ActorID:    user.ID,
+		Action:     entity.AuditChannelUpdate,
+		TargetType: entity.AuditTargetChannel,
+		TargetID:   channel.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -136,6 +243,18 @@ func (c *ChannelController) UpdateChannel(w http.ResponseWriter, r *http.Request
 
 // DeleteChannel deletes a channel
 func (c *ChannelController) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	channelIdStr := vars["id"]
 
@@ -151,10 +270,29 @@ func (c *ChannelController) DeleteChannel(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	allowed, err := c.permissionService.HasServerPermission(channel.GetServerID(), user.ID, entity.PermissionManageChannels)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to manage channels", http.StatusForbidden)
+		return
+	}
+
 	if err := c.channelService.DeleteChannel(channel); err != nil {
 		http.Error(w, "Failed to delete channel", http.StatusInternalServerError)
 		return
 	}
+	ws.BroadcastChannelDelete(channel)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", channel.Name, nil)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   channel.GetServerID(),
+		ActorID:    user.ID,
+		Action:     entity.AuditChannelDelete,
+		TargetType: entity.AuditTargetChannel,
+		TargetID:   channel.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -164,15 +302,440 @@ func (c *ChannelController) DeleteChannel(w http.ResponseWriter, r *http.Request
 
 // GetServerChannels returns all channels for a specific server
 func (c *ChannelController) GetServerChannels(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	serverId := vars["id"]
 
+	serverIdUint, err := strconv.ParseUint(serverId, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(uint(serverIdUint), user.ID)
+	if err != nil || !isMember {
+		http.Error(w, "You are not a member of this server", http.StatusForbidden)
+		return
+	}
+
 	channels, err := c.channelService.GetServerChannels(serverId)
 	if err != nil {
 		http.Error(w, "Failed to fetch server channels", http.StatusInternalServerError)
 		return
 	}
 
+	// Only return the channels the user can view
+	visibleChannels := make([]entity.Channel, 0, len(channels))
+	for _, channel := range channels {
+		allowed, err := c.permissionService.CanAccessChannel(channel.ID, user.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch channel permissions", http.StatusInternalServerError)
+			return
+		}
+		if allowed {
+			visibleChannels = append(visibleChannels, channel)
+		}
+	}
+
+	channelsWithReadState, err := c.readStateService.GetChannelsWithReadState(user.ID, visibleChannels)
+	if err != nil {
+		http.Error(w, "Failed to fetch read states", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(channelsWithReadState)
+}
+
+// GetChannelOverwrites returns the permission overwrites of a channel
+func (c *ChannelController) GetChannelOverwrites(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := c.channelService.GetChannel(uint(channelId))
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	allowed, err := c.permissionService.HasServerPermission(channel.GetServerID(), user.ID, entity.PermissionManageChannels)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to manage channels", http.StatusForbidden)
+		return
+	}
+
+	overwrites, err := c.channelService.GetChannelOverwrites(channel.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channel overwrites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(overwrites)
+}
+
+// SetChannelOverwrite creates or replaces the overwrite of a role, a member or everyone in a channel
+func (c *ChannelController) SetChannelOverwrite(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var overwrite entity.ChannelOverwrite
+	if err := json.NewDecoder(r.Body).Decode(&overwrite); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	switch overwrite.TargetType {
+	case entity.OverwriteEveryone:
+		overwrite.TargetID = 0
+	case entity.OverwriteRole, entity.OverwriteMember:
+		if overwrite.TargetID == 0 {
+			http.Error(w, "Target ID is required", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid target type", http.StatusBadRequest)
+		return
+	}
+
+	if overwrite.Allow&overwrite.Deny != 0 {
+		http.Error(w, "A permission cannot be both allowed and denied", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := c.channelService.GetChannel(uint(channelId))
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	if !c.canOverwrite(w, channel, user.ID, &overwrite) {
+		return
+	}
+
+	// Keep the previous values for the audit log
+	changes := entity.AuditLogChanges{}
+	var previousAllow, previousDeny int64
+	overwrites, err := c.channelService.GetChannelOverwrites(channel.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channel overwrites", http.StatusInternalServerError)
+		return
+	}
+	for _, existing := range overwrites {
+		if existing.TargetType == overwrite.TargetType && existing.TargetID == overwrite.TargetID {
+			previousAllow, previousDeny = existing.Allow, existing.Deny
+		}
+	}
+
+	overwrite.ID = 0
+	overwrite.ChannelID = channel.ID
+
+	if err := c.channelService.SaveChannelOverwrite(&overwrite); err != nil {
+		http.Error(w, "Failed to save channel overwrite", http.StatusInternalServerError)
+		return
+	}
+	// The overwrite can change who sees the channel
+	ws.BroadcastChannelUpdate(c.channelService.DB, channel)
+
+	changes.Set("targetType", nil, overwrite.TargetType)
+	changes.Set("targetId", nil, overwrite.TargetID)
+	changes.Set("allow", previousAllow, overwrite.Allow)
+	changes.Set("deny", previousDeny, overwrite.Deny)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   channel.GetServerID(),
+		ActorID:    user.ID,
+		Action:     entity.AuditOverwriteUpdate,
+		TargetType: entity.AuditTargetChannel,
+		TargetID:   channel.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(overwrite)
+}
+
+// canOverwrite checks that the user can manage channels, that the target of the overwrite
+// belongs to the server of the channel, and that the user holds in the channel every
+// permission the overwrite allows or denies
+func (c *ChannelController) canOverwrite(w http.ResponseWriter, channel *entity.Channel, userId uint, overwrite *entity.ChannelOverwrite) bool {
+	allowed, err := c.permissionService.HasServerPermission(channel.GetServerID(), userId, entity.PermissionManageChannels)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to manage channels", http.StatusForbidden)
+		return false
+	}
+
+	valid, err := c.permissionService.IsValidOverwriteTarget(channel.GetServerID(), overwrite.TargetType, overwrite.TargetID)
+	if err != nil {
+		http.Error(w, "Failed to fetch overwrite target", http.StatusInternalServerError)
+		return false
+	}
+	if !valid {
+		http.Error(w, "Target is not a role or member of this server", http.StatusBadRequest)
+		return false
+	}
+
+	// A user cannot allow or deny permissions they don't have themselves in the channel
+	permissions, err := c.permissionService.GetChannelPermissions(channel.ID, userId)
+	if err != nil {
+		http.Error(w, "Failed to fetch channel permissions", http.StatusInternalServerError)
+		return false
+	}
+	if (overwrite.Allow|overwrite.Deny)&^permissions != 0 {
+		http.Error(w, "You cannot overwrite permissions you don't have", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// DeleteChannelOverwrite removes the overwrite of a role, a member or everyone in a channel
+func (c *ChannelController) DeleteChannelOverwrite(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+	targetId, err := strconv.ParseUint(vars["targetId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid target ID", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := c.channelService.GetChannel(uint(channelId))
+	if err != nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	overwrites, err := c.channelService.GetChannelOverwrites(channel.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch channel overwrites", http.StatusInternalServerError)
+		return
+	}
+	var overwrite *entity.ChannelOverwrite
+	for i, existing := range overwrites {
+		if existing.TargetType == vars["targetType"] && existing.TargetID == uint(targetId) {
+			overwrite = &overwrites[i]
+		}
+	}
+	if overwrite == nil {
+		http.Error(w, "Channel overwrite not found", http.StatusNotFound)
+		return
+	}
+
+	if !c.canOverwrite(w, channel, user.ID, overwrite) {
+		return
+	}
+
+	if err := c.channelService.DeleteChannelOverwrite(channel.ID, vars["targetType"], uint(targetId)); err != nil {
+		http.Error(w, "Failed to delete channel overwrite", http.StatusInternalServerError)
+		return
+	}
+	ws.BroadcastChannelUpdate(c.channelService.DB, channel)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("targetType", vars["targetType"], nil)
+	changes.Set("targetId", uint(targetId), nil)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   channel.GetServerID(),
+		ActorID:    user.ID,
+		Action:     entity.AuditOverwriteDelete,
+		TargetType: entity.AuditTargetChannel,
+		TargetID:   channel.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Channel overwrite deleted successfully",
+	})
+}
+
+// AckChannel marks a channel as read up to the given message, or up to its latest message
+func (c *ChannelController) AckChannel(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		MessageID uint `json:"messageId"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(uint(channelId), user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
+	messageId, err := c.readStateService.AckChannel(user.ID, uint(channelId), input.MessageID)
+	if err != nil {
+		if err == services.ErrMessageNotInChannel {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to acknowledge channel", http.StatusInternalServerError)
+		return
+	}
+
+	ws.NotifyReadState(user.ID, uint(channelId), messageId)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]uint{
+		"channelId": uint(channelId),
+		"messageId": messageId,
+	})
+}
+
+// OpenDirectChannel opens a direct message channel with one user, or a group
+// direct message channel with several users
+func (c *ChannelController) OpenDirectChannel(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		RecipientIDs []uint `json:"recipientIds"`
+		Name         string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	channel, created, err := c.channelService.OpenDirectChannel(user.ID, input.RecipientIDs, input.Name)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidRecipients, services.ErrTooManyRecipients:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case services.ErrBlocked:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Failed to open direct message channel", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	response := entity.ChannelWithReadState{Channel: *channel}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response.ToDirectMessageResponse())
+}
+
+// GetDirectChannels returns the direct message channels of the current user, most recently active first
+func (c *ChannelController) GetDirectChannels(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	channels, err := c.channelService.GetUserDirectChannels(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch direct message channels", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(channels)
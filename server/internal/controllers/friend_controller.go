@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
+)
+
+type FriendController struct {
+	friendshipService *services.FriendshipService
+}
+
+func NewFriendController(friendshipService *services.FriendshipService) *FriendController {
+	return &FriendController{
+		friendshipService: friendshipService,
+	}
+}
+
+// GetFriends returns the friends of the current user
+func (c *FriendController) GetFriends(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	friends, err := c.friendshipService.GetFriends(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch friends", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(friends)
+}
+
+// GetFriendRequests returns the pending friend requests sent to and by the current user
+func (c *FriendController) GetFriendRequests(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	incoming, outgoing, err := c.friendshipService.GetPendingRequests(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch friend requests", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string][]entity.FriendshipResponse{
+		"incoming": incoming,
+		"outgoing": outgoing,
+	})
+}
+
+// SendFriendRequest sends a friend request to a user, accepting theirs if they already sent one
+func (c *FriendController) SendFriendRequest(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		UserID uint `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	friendship, err := c.friendshipService.SendFriendRequest(user.ID, input.UserID)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			http.Error(w, "User not found", http.StatusNotFound)
+		case services.ErrAlreadyFriends, services.ErrFriendRequestExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case services.ErrInvalidFriendRequest:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case services.ErrFriendRequestBlocked:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Failed to send friend request", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Sending a request to someone who already sent one accepts it
+	if friendship.Status == entity.FriendshipAccepted {
+		ws.NotifyFriendship("FRIEND_UPDATE", friendship)
+	} else {
+		ws.NotifyFriendship("FRIEND_REQUEST", friendship)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(friendship.ToResponse(user.ID))
+}
+
+// AcceptFriendRequest accepts a friend request sent to the current user
+func (c *FriendController) AcceptFriendRequest(w http.ResponseWriter, r *http.Request) {
+	c.updateFriendship(w, r, c.friendshipService.AcceptFriendRequest, "Friend request accepted successfully")
+}
+
+// DeclineFriendRequest declines a friend request sent to the current user
+func (c *FriendController) DeclineFriendRequest(w http.ResponseWriter, r *http.Request) {
+	c.updateFriendship(w, r, c.friendshipService.DeclineFriendRequest, "Friend request declined successfully")
+}
+
+// CancelFriendRequest cancels a friend request sent by the current user
+func (c *FriendController) CancelFriendRequest(w http.ResponseWriter, r *http.Request) {
+	c.updateFriendship(w, r, c.friendshipService.CancelFriendRequest, "Friend request cancelled successfully")
+}
+
+// RemoveFriend ends a friendship of the current user
+func (c *FriendController) RemoveFriend(w http.ResponseWriter, r *http.Request) {
+	c.updateFriendship(w, r, c.friendshipService.RemoveFriend, "Friend removed successfully")
+}
+
+// GetBlockedUsers returns the users blocked by the current user
+func (c *FriendController) GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := c.friendshipService.GetBlockedUsers(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch blocked users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+// BlockUser blocks a user, ending any friendship or pending request with them
+func (c *FriendController) BlockUser(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	targetId, err := strconv.ParseUint(vars["userId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	ended, block, err := c.friendshipService.BlockUser(user.ID, uint(targetId))
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			http.Error(w, "User not found", http.StatusNotFound)
+		case services.ErrInvalidBlock:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if ended != nil {
+		ws.NotifyFriendship("FRIEND_UPDATE", ended)
+	}
+	ws.NotifyRelationship(user.ID, "FRIEND_UPDATE", block)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "User blocked successfully",
+	})
+}
+
+// UnblockUser removes the block of the current user on a user
+func (c *FriendController) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	targetId, err := strconv.ParseUint(vars["userId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	block, err := c.friendshipService.UnblockUser(user.ID, uint(targetId))
+	if err != nil {
+		if err == services.ErrNotBlocked {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+
+	ws.NotifyRelationship(user.ID, "FRIEND_UPDATE", block)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "User unblocked successfully",
+	})
+}
+
+// updateFriendship applies a change to the relationship with the user of the request
+// and sends a FRIEND_UPDATE event to both users
+func (c *FriendController) updateFriendship(w http.ResponseWriter, r *http.Request, update func(userID uint, otherID uint) (*entity.Friendship, error), message string) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	otherId, err := strconv.ParseUint(vars["userId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	friendship, err := update(user.ID, uint(otherId))
+	if err != nil {
+		switch err {
+		case services.ErrNoFriendRequest, services.ErrNotFriends:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to update friendship", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ws.NotifyFriendship("FRIEND_UPDATE", friendship)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": message,
+	})
+}
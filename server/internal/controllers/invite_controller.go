@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
+)
+
+type InviteController struct {
+	inviteService     *services.InviteService
+	permissionService *services.PermissionService
+	auditLogService   *services.AuditLogService
+}
+
+func NewInviteController(inviteService *services.InviteService, permissionService *services.PermissionService, auditLogService *services.AuditLogService) *InviteController {
+	return &InviteController{
+		inviteService:     inviteService,
+		permissionService: permissionService,
+		auditLogService:   auditLogService,
+	}
+}
+
+// CreateInvite creates an invite link for a server
+func (c *InviteController) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	var requestData struct {
+		MaxUses   int  `json:"maxUses"`
+		MaxAge    int  `json:"maxAge"` // In seconds, 0 means the invite never expires
+		Temporary bool `json:"temporary"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if requestData.MaxUses < 0 || requestData.MaxAge < 0 {
+		http.Error(w, "Max uses and max age cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(uint(serverId), user.ID)
+	if err != nil || !isMember {
+		http.Error(w, "You are not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	invite := entity.Invite{
+		ServerID:  uint(serverId),
+		UserID:    user.ID,
+		MaxUses:   requestData.MaxUses,
+		Temporary: requestData.Temporary,
+	}
+	if requestData.MaxAge > 0 {
+		expiresAt := time.Now().Add(time.Duration(requestData.MaxAge) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if err := c.inviteService.CreateInvite(&invite); err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("code", nil, invite.Code)
+	changes.Set("maxUses", nil, invite.MaxUses)
+	changes.Set("expiresAt", nil, invite.ExpiresAt)
+	changes.Set("temporary", nil, invite.Temporary)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   invite.ServerID,
+		ActorID:    user.ID,
+		Action:     entity.AuditInviteCreate,
+		TargetType: entity.AuditTargetInvite,
+		TargetID:   invite.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite.ToResponse())
+}
+
+// GetServerInvites returns all invites of a server
+func (c *InviteController) GetServerInvites(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := c.permissionService.HasServerPermission(uint(serverId), user.ID, entity.PermissionManageServer)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to manage this server", http.StatusForbidden)
+		return
+	}
+
+	invites, err := c.inviteService.GetServerInvites(vars["id"])
+	if err != nil {
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]entity.InviteResponse, len(invites))
+	for i, invite := range invites {
+		response[i] = invite.ToResponse()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeInvite deletes an invite
+func (c *InviteController) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	invite, err := c.inviteService.GetInviteByCode(vars["code"])
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	// The creator of the invite can always revoke it
+	if invite.UserID != user.ID {
+		allowed, err := c.permissionService.HasServerPermission(invite.ServerID, user.ID, entity.PermissionManageServer)
+		if err != nil || !allowed {
+			http.Error(w, "You are not allowed to revoke this invite", http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := c.inviteService.DeleteInvite(invite); err != nil {
+		http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("code", invite.Code, nil)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   invite.ServerID,
+		ActorID:    user.ID,
+		Action:     entity.AuditInviteDelete,
+		TargetType: entity.AuditTargetInvite,
+		TargetID:   invite.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Invite revoked successfully",
+	})
+}
+
+// AcceptInvite joins the server of the invite
+func (c *InviteController) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	server, err := c.inviteService.AcceptInvite(vars["code"], user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, "Invite not found", http.StatusNotFound)
+		case errors.Is(err, services.ErrInviteExpired):
+			http.Error(w, "Invite is expired", http.StatusGone)
+		case errors.Is(err, services.ErrAlreadyMember):
+			http.Error(w, "You are already a member of this server", http.StatusConflict)
+		case errors.Is(err, services.ErrBanned):
+			http.Error(w, "You are banned from this server", http.StatusForbidden)
+		default:
+			http.Error(w, "Failed to accept invite", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ws.AddToServer(c.inviteService.DB, user.ID, server)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("invite", nil, vars["code"])
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   server.ID,
+		ActorID:    user.ID,
+		Action:     entity.AuditMemberJoin,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   user.ID,
+		Changes:    changes,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(server)
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,26 +14,68 @@ import (
 
 	"github.com/gorilla/mux"
 	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
 	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
 )
 
 type MessageController struct {
-	messageService *services.MessageService
+	messageService    *services.MessageService
+	threadService     *services.ThreadService
+	channelService    *services.ChannelService
+	friendshipService *services.FriendshipService
+	permissionService *services.PermissionService
+	auditLogService   *services.AuditLogService
 }
 
-func NewMessageController(messageService *services.MessageService) *MessageController {
+func NewMessageController(messageService *services.MessageService, threadService *services.ThreadService, channelService *services.ChannelService, friendshipService *services.FriendshipService, permissionService *services.PermissionService, auditLogService *services.AuditLogService) *MessageController {
 	return &MessageController{
-		messageService: messageService,
+		messageService:    messageService,
+		threadService:     threadService,
+		channelService:    channelService,
+		friendshipService: friendshipService,
+		permissionService: permissionService,
+		auditLogService:   auditLogService,
 	}
 }
 
-// GetChannelMessages returns all messages in a channel
+// GetChannelMessages returns a page of messages in a channel, selected with
+// the before, after or around message ID and limit query parameters
 func (c *MessageController) GetChannelMessages(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
 
 	vars := mux.Vars(r)
 	channelId := vars["channelID"]
 
-	messages, err := c.messageService.GetChannelMessages(channelId)
+	channelIdUint, err := strconv.ParseUint(channelId, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(uint(channelIdUint), user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
+	page, err := parseMessagePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := c.messageService.GetChannelMessages(channelId, user.ID, page)
 	if err != nil {
 		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
 		return
@@ -45,6 +88,18 @@ func (c *MessageController) GetChannelMessages(w http.ResponseWriter, r *http.Re
 
 // GetMessage returns a specific message by ID
 func (c *MessageController) GetMessage(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	messageId := vars["id"]
 
@@ -54,6 +109,12 @@ func (c *MessageController) GetMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	allowed, err := c.permissionService.CanAccessChannel(message.ChannelID, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(message)
@@ -85,40 +146,176 @@ func (c *MessageController) CreateMessage(w http.ResponseWriter, r *http.Request
 	message.ChannelID = uint(channelIDUint)
 	message.Content = r.FormValue("content")
 
+	// Messages posted in a thread are stored in the thread's parent channel
+	var thread *entity.Thread
+	if threadID := r.FormValue("threadID"); threadID != "" {
+		threadIDUint, err := strconv.ParseUint(threadID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid thread ID", http.StatusBadRequest)
+			return
+		}
+		thread, err = c.threadService.AttachMessage(&message, uint(threadIDUint))
+		if err != nil {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	if referencedMessageID := r.FormValue("referencedMessageID"); referencedMessageID != "" {
+		referencedMessageIDUint, err := strconv.ParseUint(referencedMessageID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid referenced message ID", http.StatusBadRequest)
+			return
+		}
+		id := uint(referencedMessageIDUint)
+		message.ReferencedMessageID = &id
+	}
+
+	allowed, err := c.permissionService.HasChannelPermission(message.ChannelID, userID, entity.PermissionViewChannel|entity.PermissionSendMessages)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to send messages in this channel", http.StatusForbidden)
+		return
+	}
+
+	if err := c.messageService.ValidateReference(&message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err := c.messageService.CreateMessage(&message); err != nil {
 		http.Error(w, "Failed to create message", http.StatusInternalServerError)
 		return
 	}
+	ws.StopTyping(user.ID, message.ChannelID)
+
+	if thread != nil {
+		c.threadService.RecordMessage(thread, &message)
+	}
+
+	if created, err := c.messageService.GetMessage(fmt.Sprintf("%d", message.ID)); err == nil {
+		ws.NotifyMentions(created, message.MentionedUsers)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(message)
 }
 
-// PinMessage pins a message
-func (c *MessageController) PinMessage(w http.ResponseWriter, r *http.Request) {
+// EditMessage replaces the content of a message, only its author can edit it
+func (c *MessageController) EditMessage(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	messageId := vars["id"]
 
+	content := r.FormValue("content")
+	if strings.TrimSpace(content) == "" {
+		http.Error(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+
 	message, err := c.messageService.GetMessage(messageId)
 	if err != nil {
 		http.Error(w, "Message not found", http.StatusNotFound)
 		return
 	}
 
-	if err := c.messageService.PinMessage(message); err != nil {
-		http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+	if message.UserID != user.ID {
+		http.Error(w, "You are not allowed to edit this message", http.StatusForbidden)
+		return
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(message.ChannelID, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
+	if err := c.messageService.EditMessage(message, content); err != nil {
+		http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		return
+	}
+
+	ws.BroadcastMessageEdit(c.messageService.DB, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(message.ToResponse())
+}
+
+// DeleteMessage deletes a message, its author and moderators can delete it
+func (c *MessageController) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
 		return
 	}
 
+	vars := mux.Vars(r)
+	messageId := vars["id"]
+
+	message, err := c.messageService.GetMessage(messageId)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	allowed, err := c.permissionService.CanDeleteMessage(message, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to delete this message", http.StatusForbidden)
+		return
+	}
+
+	if err := c.messageService.DeleteMessage(message); err != nil {
+		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		return
+	}
+
+	// Deleting someone else's message is a moderation action
+	if message.UserID != user.ID {
+		changes := entity.AuditLogChanges{}
+		changes.Set("content", message.Content, nil)
+		c.recordMessageAction(r, message, user.ID, entity.AuditMessageDelete, changes)
+	}
+
+	ws.BroadcastMessageDelete(c.messageService.DB, message)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Message pinned successfully",
+		"message": "Message deleted successfully",
 	})
 }
 
-// UnpinMessage unpins a message
-func (c *MessageController) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+// GetMessageRevisions returns the previous contents of a message, oldest first
+func (c *MessageController) GetMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	messageId := vars["id"]
 
@@ -128,17 +325,147 @@ func (c *MessageController) UnpinMessage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	allowed, err := c.permissionService.CanViewRevisions(message, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to view the revisions of this message", http.StatusForbidden)
+		return
+	}
+
+	revisions, err := c.messageService.GetRevisions(messageId)
+	if err != nil {
+		http.Error(w, "Failed to fetch revisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetChannelPins returns the pinned messages of a channel, last pinned first, with who pinned them
+func (c *MessageController) GetChannelPins(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(uint(channelId), user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
+	pins, err := c.messageService.GetChannelPins(uint(channelId), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch pinned messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pins)
+}
+
+// PinMessage pins a message of a channel
+func (c *MessageController) PinMessage(w http.ResponseWriter, r *http.Request) {
+	message, user, ok := c.getPinnableMessage(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.messageService.PinMessage(message, user.ID); err != nil {
+		if err == services.ErrTooManyPins {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+		return
+	}
+
+	c.recordMessageAction(r, message, user.ID, entity.AuditMessagePin, nil)
+	ws.BroadcastChannelPinsUpdate(c.messageService.DB, message)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Message pinned successfully",
+	})
+}
+
+// UnpinMessage unpins a message of a channel
+func (c *MessageController) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	message, user, ok := c.getPinnableMessage(w, r)
+	if !ok {
+		return
+	}
+	if !message.Pinned {
+		http.Error(w, "Message is not pinned", http.StatusNotFound)
+		return
+	}
+
 	if err := c.messageService.UnpinMessage(message); err != nil {
 		http.Error(w, "Failed to unpin message", http.StatusInternalServerError)
 		return
 	}
 
+	c.recordMessageAction(r, message, user.ID, entity.AuditMessageUnpin, nil)
+	ws.BroadcastChannelPinsUpdate(c.messageService.DB, message)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Message unpinned successfully",
 	})
 }
 
+// getPinnableMessage loads the message of the pin routes and checks that the current user
+// can pin messages in its channel. It writes the error response when it cannot.
+func (c *MessageController) getPinnableMessage(w http.ResponseWriter, r *http.Request) (*entity.Message, *entity.User, bool) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	vars := mux.Vars(r)
+	channelId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	message, err := c.messageService.GetMessage(vars["messageId"])
+	if err != nil || message.ChannelID != uint(channelId) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	allowed, err := c.permissionService.HasChannelPermission(message.ChannelID, user.ID, entity.PermissionViewChannel|entity.PermissionPinMessages)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to pin messages in this channel", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return message, user, true
+}
+
 // AddReaction adds a reaction to a message
 func (c *MessageController) AddReaction(w http.ResponseWriter, r *http.Request) {
 	var reaction entity.Reaction
@@ -163,6 +490,24 @@ func (c *MessageController) AddReaction(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	message, err := c.messageService.GetMessage(messageId)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(message.ChannelID, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
+	blocked, err := c.friendshipService.IsBlocked(message.UserID, user.ID)
+	if err != nil || blocked {
+		http.Error(w, "You are not allowed to react to this message", http.StatusForbidden)
+		return
+	}
+
 	userId := user.ID
 	reaction.UserID = userId
 	reaction.Emoji = r.FormValue("emoji")
@@ -180,6 +525,18 @@ func (c *MessageController) AddReaction(w http.ResponseWriter, r *http.Request)
 
 // RemoveReaction removes a reaction from a message
 func (c *MessageController) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	messageId := vars["id"]
 	reactionId := vars["reactionId"]
@@ -204,6 +561,11 @@ func (c *MessageController) RemoveReaction(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if reactionToRemove.UserID != user.ID {
+		http.Error(w, "You are not allowed to remove this reaction", http.StatusForbidden)
+		return
+	}
+
 	if err := c.messageService.RemoveReaction(reactionToRemove); err != nil {
 		http.Error(w, "Failed to remove reaction", http.StatusInternalServerError)
 		return
@@ -245,6 +607,12 @@ func (c *MessageController) AddMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	allowed, err := c.permissionService.HasChannelPermission(message.ChannelID, user.ID, entity.PermissionAttachFiles)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to attach files in this channel", http.StatusForbidden)
+		return
+	}
+
 	file, handler, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "Failed to get file", http.StatusBadRequest)
@@ -319,3 +687,50 @@ func (c *MessageController) AddMedia(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(media)
 }
+
+// recordMessageAction records an action on a message in the audit log of its server,
+// direct messages have no audit log
+func (c *MessageController) recordMessageAction(r *http.Request, message *entity.Message, actorId uint, action string, changes entity.AuditLogChanges) {
+	channel, err := c.channelService.GetChannel(message.ChannelID)
+	if err != nil || channel.IsDirect() {
+		return
+	}
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   *channel.ServerID,
+		ActorID:    actorId,
+		Action:     action,
+		TargetType: entity.AuditTargetMessage,
+		TargetID:   message.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+}
+
+// parseMessagePage reads the before, after, around and limit query parameters
+func parseMessagePage(r *http.Request) (repositories.MessagePage, error) {
+	var page repositories.MessagePage
+	cursors := 0
+	for param, cursor := range map[string]*uint{"before": &page.Before, "after": &page.After, "around": &page.Around} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return page, fmt.Errorf("Invalid %s message ID", param)
+		}
+		*cursor = uint(id)
+		cursors++
+	}
+	if cursors > 1 {
+		return page, errors.New("Only one of before, after and around can be used")
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		var err error
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return page, errors.New("Invalid limit")
+		}
+	}
+	return page, nil
+}
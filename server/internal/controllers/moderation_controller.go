@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
+)
+
+type ModerationController struct {
+	moderationService *services.ModerationService
+	channelService    *services.ChannelService
+	permissionService *services.PermissionService
+	auditLogService   *services.AuditLogService
+}
+
+func NewModerationController(moderationService *services.ModerationService, channelService *services.ChannelService, permissionService *services.PermissionService, auditLogService *services.AuditLogService) *ModerationController {
+	return &ModerationController{
+		moderationService: moderationService,
+		channelService:    channelService,
+		permissionService: permissionService,
+		auditLogService:   auditLogService,
+	}
+}
+
+// KickMember removes a member from a server
+func (c *ModerationController) KickMember(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	serverId, memberId, ok := parseServerMember(w, r)
+	if !ok {
+		return
+	}
+
+	if !c.checkModeration(w, serverId, user.ID, memberId, entity.PermissionKickMembers) {
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(serverId, memberId)
+	if err != nil || !isMember {
+		http.Error(w, "User is not a member of this server", http.StatusNotFound)
+		return
+	}
+
+	if err := c.moderationService.KickMember(serverId, memberId); err != nil {
+		http.Error(w, "Failed to kick member", http.StatusInternalServerError)
+		return
+	}
+
+	c.notifyRemoval(serverId, memberId, "kick")
+
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   serverId,
+		ActorID:    user.ID,
+		Action:     entity.AuditMemberKick,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   memberId,
+		Reason:     auditLogReason(r),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Member kicked successfully",
+	})
+}
+
+// BanMember bans a user from a server, with an optional duration in seconds
+func (c *ModerationController) BanMember(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	serverId, memberId, ok := parseServerMember(w, r)
+	if !ok {
+		return
+	}
+
+	var requestData struct {
+		Reason   string `json:"reason"`
+		Duration int    `json:"duration"` // In seconds, 0 means the ban is permanent
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if requestData.Duration < 0 {
+		http.Error(w, "Duration cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	if !c.checkModeration(w, serverId, user.ID, memberId, entity.PermissionBanMembers) {
+		return
+	}
+
+	ban := entity.Ban{
+		ServerID:    serverId,
+		UserID:      memberId,
+		ModeratorID: user.ID,
+		Reason:      requestData.Reason,
+	}
+	if ban.Reason == "" {
+		ban.Reason = auditLogReason(r)
+	}
+	if requestData.Duration > 0 {
+		expiresAt := time.Now().Add(time.Duration(requestData.Duration) * time.Second)
+		ban.ExpiresAt = &expiresAt
+	}
+
+	if err := c.moderationService.BanMember(&ban); err != nil {
+		http.Error(w, "Failed to ban user", http.StatusInternalServerError)
+		return
+	}
+
+	c.notifyRemoval(serverId, memberId, "ban")
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("expiresAt", nil, ban.ExpiresAt)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   serverId,
+		ActorID:    user.ID,
+		Action:     entity.AuditMemberBan,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   memberId,
+		Changes:    changes,
+		Reason:     ban.Reason,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ban.ToResponse())
+}
+
+// UnbanMember lifts the ban of a user
+func (c *ModerationController) UnbanMember(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	serverId, memberId, ok := parseServerMember(w, r)
+	if !ok {
+		return
+	}
+
+	allowed, err := c.permissionService.HasServerPermission(serverId, user.ID, entity.PermissionBanMembers)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to ban members", http.StatusForbidden)
+		return
+	}
+
+	if err := c.moderationService.UnbanMember(serverId, memberId); err != nil {
+		http.Error(w, "Failed to unban user", http.StatusInternalServerError)
+		return
+	}
+
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   serverId,
+		ActorID:    user.ID,
+		Action:     entity.AuditMemberUnban,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   memberId,
+		Reason:     auditLogReason(r),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "User unbanned successfully",
+	})
+}
+
+// GetServerBans returns the active bans of a server
+func (c *ModerationController) GetServerBans(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := c.permissionService.HasServerPermission(uint(serverId), user.ID, entity.PermissionBanMembers)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to ban members", http.StatusForbidden)
+		return
+	}
+
+	bans, err := c.moderationService.GetServerBans(vars["id"])
+	if err != nil {
+		http.Error(w, "Failed to fetch bans", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]entity.BanResponse, len(bans))
+	for i, ban := range bans {
+		response[i] = ban.ToResponse()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// TimeoutMember prevents a member from sending messages for a duration in seconds, 0 clears the timeout
+func (c *ModerationController) TimeoutMember(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	serverId, memberId, ok := parseServerMember(w, r)
+	if !ok {
+		return
+	}
+
+	var requestData struct {
+		Duration int `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if requestData.Duration < 0 {
+		http.Error(w, "Duration cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	if !c.checkModeration(w, serverId, user.ID, memberId, entity.PermissionModerateMembers) {
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(serverId, memberId)
+	if err != nil || !isMember {
+		http.Error(w, "User is not a member of this server", http.StatusNotFound)
+		return
+	}
+
+	var until *time.Time
+	if requestData.Duration > 0 {
+		timeoutUntil := time.Now().Add(time.Duration(requestData.Duration) * time.Second)
+		until = &timeoutUntil
+	}
+
+	if err := c.moderationService.TimeoutMember(serverId, memberId, until); err != nil {
+		http.Error(w, "Failed to timeout member", http.StatusInternalServerError)
+		return
+	}
+
+	ws.NotifyTimeout(memberId, serverId, until)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("timeoutUntil", nil, until)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   serverId,
+		ActorID:    user.ID,
+		Action:     entity.AuditMemberTimeout,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   memberId,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Member timeout updated successfully",
+		"until":   until,
+	})
+}
+
+// checkModeration writes an error and returns false if the moderator cannot act on the target
+func (c *ModerationController) checkModeration(w http.ResponseWriter, serverId uint, moderatorId uint, targetId uint, permission int64) bool {
+	allowed, err := c.permissionService.HasServerPermission(serverId, moderatorId, permission)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to moderate members", http.StatusForbidden)
+		return false
+	}
+
+	allowed, err = c.permissionService.CanModerate(serverId, moderatorId, targetId)
+	if err != nil || !allowed {
+		http.Error(w, "You cannot moderate this user", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// notifyRemoval makes the clients of the user drop the server
+func (c *ModerationController) notifyRemoval(serverId uint, userId uint, reason string) {
+	channels, err := c.channelService.GetServerChannels(fmt.Sprintf("%d", serverId))
+	if err != nil {
+		return
+	}
+	channelIDs := make([]uint, len(channels))
+	for i, channel := range channels {
+		channelIDs[i] = channel.ID
+	}
+	ws.RemoveFromServer(userId, serverId, channelIDs, reason)
+}
+
+// parseServerMember reads the server and user IDs from the route
+func parseServerMember(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	memberId, err := strconv.ParseUint(vars["userId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return uint(serverId), uint(memberId), true
+}
@@ -0,0 +1,444 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
+)
+
+type RoleController struct {
+	roleService       *services.RoleService
+	permissionService *services.PermissionService
+	auditLogService   *services.AuditLogService
+}
+
+func NewRoleController(roleService *services.RoleService, permissionService *services.PermissionService, auditLogService *services.AuditLogService) *RoleController {
+	return &RoleController{
+		roleService:       roleService,
+		permissionService: permissionService,
+		auditLogService:   auditLogService,
+	}
+}
+
+// GetServerRoles returns all roles of a server
+func (c *RoleController) GetServerRoles(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(uint(serverId), user.ID)
+	if err != nil || !isMember {
+		http.Error(w, "You are not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	roles, err := c.roleService.GetServerRoles(vars["id"])
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(roles)
+}
+
+// CreateRole creates a new role in a server
+func (c *RoleController) CreateRole(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	var role entity.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if role.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	permissions, err := c.permissionService.GetServerPermissions(uint(serverId), user.ID)
+	if err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if permissions&entity.PermissionManageRoles == 0 {
+		http.Error(w, "You are not allowed to manage roles", http.StatusForbidden)
+		return
+	}
+	// A user cannot grant permissions they don't have themselves
+	if role.Permissions&^permissions != 0 {
+		http.Error(w, "You cannot grant permissions you don't have", http.StatusForbidden)
+		return
+	}
+	if !c.canManageRole(w, uint(serverId), user.ID, role.Position) {
+		return
+	}
+
+	role.ID = 0
+	role.ServerID = uint(serverId)
+
+	if err := c.roleService.CreateRole(&role); err != nil {
+		http.Error(w, "Failed to create role", http.StatusInternalServerError)
+		return
+	}
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", nil, role.Name)
+	changes.Set("permissions", nil, role.Permissions)
+	changes.Set("position", nil, role.Position)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   role.ServerID,
+		ActorID:    user.ID,
+		Action:     entity.AuditRoleCreate,
+		TargetType: entity.AuditTargetRole,
+		TargetID:   role.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// UpdateRole updates a role's name, permissions or position
+func (c *RoleController) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+	roleId, err := strconv.ParseUint(vars["roleId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+
+	var updateData struct {
+		Name        string `json:"name"`
+		Permissions *int64 `json:"permissions"`
+		Position    *int   `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	role, err := c.roleService.GetRole(uint(roleId))
+	if err != nil || role.ServerID != uint(serverId) {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	}
+
+	permissions, err := c.permissionService.GetServerPermissions(role.ServerID, user.ID)
+	if err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if permissions&entity.PermissionManageRoles == 0 {
+		http.Error(w, "You are not allowed to manage roles", http.StatusForbidden)
+		return
+	}
+	if !c.canManageRole(w, role.ServerID, user.ID, role.Position) {
+		return
+	}
+
+	before := *role
+
+	if updateData.Name != "" {
+		role.Name = updateData.Name
+	}
+	if updateData.Permissions != nil {
+		if *updateData.Permissions&^permissions != 0 {
+			http.Error(w, "You cannot grant permissions you don't have", http.StatusForbidden)
+			return
+		}
+		role.Permissions = *updateData.Permissions
+	}
+	if updateData.Position != nil {
+		if !c.canManageRole(w, role.ServerID, user.ID, *updateData.Position) {
+			return
+		}
+		role.Position = *updateData.Position
+	}
+
+	access, err := c.permissionService.GetServerPermissionSet(role.ServerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch permissions", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.roleService.UpdateRole(role); err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+	if role.Permissions != before.Permissions {
+		ws.SyncChannelAccess(c.roleService.DB, role.ServerID, access)
+	}
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", before.Name, role.Name)
+	changes.Set("permissions", before.Permissions, role.Permissions)
+	changes.Set("position", before.Position, role.Position)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   role.ServerID,
+		ActorID:    user.ID,
+		Action:     entity.AuditRoleUpdate,
+		TargetType: entity.AuditTargetRole,
+		TargetID:   role.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(role)
+}
+
+// DeleteRole deletes a role and removes it from every member
+func (c *RoleController) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+	roleId, err := strconv.ParseUint(vars["roleId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+
+	role, err := c.roleService.GetRole(uint(roleId))
+	if err != nil || role.ServerID != uint(serverId) {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	}
+
+	permissions, err := c.permissionService.GetServerPermissions(role.ServerID, user.ID)
+	if err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if permissions&entity.PermissionManageRoles == 0 || role.Permissions&^permissions != 0 {
+		http.Error(w, "You are not allowed to manage this role", http.StatusForbidden)
+		return
+	}
+	if !c.canManageRole(w, role.ServerID, user.ID, role.Position) {
+		return
+	}
+
+	access, err := c.permissionService.GetServerPermissionSet(role.ServerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch permissions", http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.roleService.DeleteRole(role); err != nil {
+		http.Error(w, "Failed to delete role", http.StatusInternalServerError)
+		return
+	}
+	ws.SyncChannelAccess(c.roleService.DB, role.ServerID, access)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", role.Name, nil)
+	changes.Set("permissions", role.Permissions, nil)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   role.ServerID,
+		ActorID:    user.ID,
+		Action:     entity.AuditRoleDelete,
+		TargetType: entity.AuditTargetRole,
+		TargetID:   role.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Role deleted successfully",
+	})
+}
+
+// AssignRole gives a role to a member of the server
+func (c *RoleController) AssignRole(w http.ResponseWriter, r *http.Request) {
+	c.updateMemberRole(w, r, true)
+}
+
+// RemoveRole takes a role away from a member of the server
+func (c *RoleController) RemoveRole(w http.ResponseWriter, r *http.Request) {
+	c.updateMemberRole(w, r, false)
+}
+
+func (c *RoleController) updateMemberRole(w http.ResponseWriter, r *http.Request, assign bool) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+	memberId, err := strconv.ParseUint(vars["userId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	roleId, err := strconv.ParseUint(vars["roleId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+
+	role, err := c.roleService.GetRole(uint(roleId))
+	if err != nil || role.ServerID != uint(serverId) {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	}
+
+	permissions, err := c.permissionService.GetServerPermissions(role.ServerID, user.ID)
+	if err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if permissions&entity.PermissionManageRoles == 0 || role.Permissions&^permissions != 0 {
+		http.Error(w, "You are not allowed to manage this role", http.StatusForbidden)
+		return
+	}
+	if !c.canManageRole(w, role.ServerID, user.ID, role.Position) {
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(role.ServerID, uint(memberId))
+	if err != nil || !isMember {
+		http.Error(w, "User is not a member of this server", http.StatusNotFound)
+		return
+	}
+
+	access, err := c.permissionService.GetServerPermissionSet(role.ServerID)
+	if err != nil {
+		http.Error(w, "Failed to fetch permissions", http.StatusInternalServerError)
+		return
+	}
+
+	if assign {
+		err = c.roleService.AssignRole(role.ServerID, uint(memberId), role.ID)
+	} else {
+		err = c.roleService.RemoveRole(role.ServerID, uint(memberId), role.ID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update member roles", http.StatusInternalServerError)
+		return
+	}
+	ws.SyncChannelAccess(c.roleService.DB, role.ServerID, access)
+
+	action := entity.AuditMemberRoleAdd
+	changes := entity.AuditLogChanges{}
+	if assign {
+		changes.Set("role", nil, role.Name)
+	} else {
+		action = entity.AuditMemberRoleRemove
+		changes.Set("role", role.Name, nil)
+	}
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   role.ServerID,
+		ActorID:    user.ID,
+		Action:     action,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   uint(memberId),
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Member roles updated successfully",
+	})
+}
+
+// canManageRole rejects changes to a role at or above the highest role of the user
+func (c *RoleController) canManageRole(w http.ResponseWriter, serverId uint, userId uint, position int) bool {
+	allowed, err := c.permissionService.CanManageRole(serverId, userId, position)
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		http.Error(w, "You cannot manage roles ranked at or above your highest role", http.StatusForbidden)
+		return false
+	}
+	return true
+}
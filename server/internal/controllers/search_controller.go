@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"lesha.com/server/internal/services"
+)
+
+type SearchController struct {
+	searchService     *services.SearchService
+	permissionService *services.PermissionService
+}
+
+func NewSearchController(searchService *services.SearchService, permissionService *services.PermissionService) *SearchController {
+	return &SearchController{
+		searchService:     searchService,
+		permissionService: permissionService,
+	}
+}
+
+// SearchServerMessages searches the messages of the channels of a server the user can view, newest first.
+// The content query parameter holds the search terms, and the results can be filtered with author_id,
+// channel_id, mentions (user IDs), has (image, video or audio), pinned, and before and after dates.
+// offset and limit page through the results.
+func (c *SearchController) SearchServerMessages(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(uint(serverId), user.ID)
+	if err != nil || !isMember {
+		http.Error(w, "You are not a member of this server", http.StatusForbidden)
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := c.searchService.SearchMessages(uint(serverId), user.ID, query)
+	if err != nil {
+		if err == services.ErrInvalidMediaFilter {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+// parseSearchQuery reads the search terms and filters, ID filters can be repeated
+func parseSearchQuery(values url.Values) (services.SearchQuery, error) {
+	query := services.SearchQuery{
+		Terms: strings.Fields(values.Get("content")),
+		Has:   values["has"],
+	}
+
+	for param, ids := range map[string]*[]uint{"author_id": &query.AuthorIDs, "channel_id": &query.ChannelIDs, "mentions": &query.MentionIDs} {
+		for _, value := range values[param] {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return query, fmt.Errorf("Invalid %s", param)
+			}
+			*ids = append(*ids, uint(id))
+		}
+	}
+
+	if value := values.Get("pinned"); value != "" {
+		pinned, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, errors.New("Invalid pinned")
+		}
+		query.Pinned = &pinned
+	}
+
+	for param, date := range map[string]**time.Time{"before": &query.Before, "after": &query.After} {
+		value := values.Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			parsed, err = time.Parse(time.DateOnly, value)
+		}
+		if err != nil {
+			return query, fmt.Errorf("Invalid %s date", param)
+		}
+		*date = &parsed
+	}
+
+	for param, number := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit} {
+		value := values.Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return query, fmt.Errorf("Invalid %s", param)
+		}
+		*number = parsed
+	}
+	return query, nil
+}
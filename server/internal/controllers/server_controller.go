@@ -7,22 +7,33 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
 )
 
 type ServerController struct {
-	serverService  *services.ServerService
-	channelService *services.ChannelService
+	serverService     *services.ServerService
+	channelService    *services.ChannelService
+	permissionService *services.PermissionService
+	auditLogService   *services.AuditLogService
+	readStateService  *services.ReadStateService
 }
 
-func NewServerController(serverService *services.ServerService, channelService *services.ChannelService) *ServerController {
+func NewServerController(serverService *services.ServerService, channelService *services.ChannelService, permissionService *services.PermissionService, auditLogService *services.AuditLogService, readStateService *services.ReadStateService) *ServerController {
 	return &ServerController{
-		serverService:  serverService,
-		channelService: channelService,
+		serverService:     serverService,
+		channelService:    channelService,
+		permissionService: permissionService,
+		auditLogService:   auditLogService,
+		readStateService:  readStateService,
 	}
 }
 
@@ -47,9 +58,15 @@ func (c *ServerController) GetUserServers(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	serversWithReadState, err := c.readStateService.GetServersWithReadState(userID, servers)
+	if err != nil {
+		http.Error(w, "Failed to fetch read states", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(servers)
+	json.NewEncoder(w).Encode(serversWithReadState)
 }
 
 // GetServer returns a specific server by ID
@@ -149,7 +166,7 @@ func (c *ServerController) CreateServer(w http.ResponseWriter, r *http.Request)
 	// Create default text channel
 	channel := entity.Channel{
 		Name:     "General",
-		ServerID: server.ID,
+		ServerID: &server.ID,
 	}
 	if err := c.channelService.CreateChannel(&channel); err != nil {
 		http.Error(w, "Failed to create default channel", http.StatusInternalServerError)
@@ -161,6 +178,20 @@ func (c *ServerController) CreateServer(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to add user to channel", http.StatusInternalServerError)
 		return
 	}
+	ws.AddToServer(c.serverService.DB, userID, &server)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", nil, server.Name)
+	changes.Set("description", nil, server.Description)
+	changes.Set("image", nil, server.Image)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   server.ID,
+		ActorID:    userID,
+		Action:     entity.AuditServerCreate,
+		TargetType: entity.AuditTargetServer,
+		TargetID:   server.ID,
+		Changes:    changes,
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -169,6 +200,18 @@ func (c *ServerController) CreateServer(w http.ResponseWriter, r *http.Request)
 
 // UpdateServer updates a server's information
 func (c *ServerController) UpdateServer(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	serverId := vars["id"]
 
@@ -184,6 +227,14 @@ func (c *ServerController) UpdateServer(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	allowed, err := c.permissionService.HasServerPermission(server.ID, user.ID, entity.PermissionManageServer)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to manage this server", http.StatusForbidden)
+		return
+	}
+
+	before := *server
+
 	// Update fields
 	if updateData.Name != "" {
 		server.Name = updateData.Name
@@ -199,14 +250,41 @@ func (c *ServerController) UpdateServer(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Failed to update server", http.StatusInternalServerError)
 		return
 	}
+	ws.BroadcastServerUpdate(server)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", before.Name, server.Name)
+	changes.Set("description", before.Description, server.Description)
+	changes.Set("image", before.Image, server.Image)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   server.ID,
+		ActorID:    user.ID,
+		Action:     entity.AuditServerUpdate,
+		TargetType: entity.AuditTargetServer,
+		TargetID:   server.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(server)
+	json.NewEncoder(w).Encode(server.ToResponse())
 }
 
 // DeleteServer deletes a server
 func (c *ServerController) DeleteServer(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	serverId := vars["id"]
 
@@ -216,10 +294,40 @@ func (c *ServerController) DeleteServer(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Only the owner can delete a server
+	if server.UserID != user.ID {
+		http.Error(w, "You are not allowed to delete this server", http.StatusForbidden)
+		return
+	}
+
+	// Keep the channels to unsubscribe the members once the server is gone
+	channels, err := c.channelService.GetServerChannels(serverId)
+	if err != nil {
+		http.Error(w, "Failed to fetch server channels", http.StatusInternalServerError)
+		return
+	}
+	channelIDs := make([]uint, len(channels))
+	for i, channel := range channels {
+		channelIDs[i] = channel.ID
+	}
+
 	if err := c.serverService.DeleteServer(server); err != nil {
 		http.Error(w, "Failed to delete server", http.StatusInternalServerError)
 		return
 	}
+	ws.BroadcastServerDelete(server, channelIDs)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("name", server.Name, nil)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   server.ID,
+		ActorID:    user.ID,
+		Action:     entity.AuditServerDelete,
+		TargetType: entity.AuditTargetServer,
+		TargetID:   server.ID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -229,6 +337,18 @@ func (c *ServerController) DeleteServer(w http.ResponseWriter, r *http.Request)
 
 // AddUserToServerByEmail adds a user to a server using their email
 func (c *ServerController) AddUserToServerByEmail(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	currentUser, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
 	vars := mux.Vars(r)
 	serverId := vars["id"]
 
@@ -237,7 +357,7 @@ func (c *ServerController) AddUserToServerByEmail(w http.ResponseWriter, r *http
 		Email string `json:"email"`
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&requestData)
+	err = json.NewDecoder(r.Body).Decode(&requestData)
 	if err != nil {
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
 		return
@@ -255,6 +375,12 @@ func (c *ServerController) AddUserToServerByEmail(w http.ResponseWriter, r *http
 		return
 	}
 
+	isMember, err := c.permissionService.IsServerMember(server.ID, currentUser.ID)
+	if err != nil || !isMember {
+		http.Error(w, "You are not a member of this server", http.StatusForbidden)
+		return
+	}
+
 	// First get the user by email
 	user, err := c.serverService.GetUserByEmail(requestData.Email)
 	if err != nil {
@@ -262,14 +388,225 @@ func (c *ServerController) AddUserToServerByEmail(w http.ResponseWriter, r *http
 		return
 	}
 
+	banned, err := c.serverService.IsUserBanned(server.ID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check bans", http.StatusInternalServerError)
+		return
+	}
+	if banned {
+		http.Error(w, "User is banned from this server", http.StatusForbidden)
+		return
+	}
+
 	// Add user to server
 	if err := c.serverService.AddUserToServer(server.ID, user.ID); err != nil {
 		http.Error(w, "Failed to add user to server", http.StatusInternalServerError)
 		return
 	}
+	ws.AddToServer(c.serverService.DB, user.ID, server)
+
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   server.ID,
+		ActorID:    currentUser.ID,
+		Action:     entity.AuditMemberAdd,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   user.ID,
+		Reason:     auditLogReason(r),
+	})
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "User added to server successfully",
 	})
 }
+
+// TransferOwnership gives the ownership of a server to another member
+func (c *ServerController) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId := vars["id"]
+
+	var requestData struct {
+		UserID   uint   `json:"userId"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	server, err := c.serverService.GetServer(serverId)
+	if err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+
+	if server.UserID != user.ID {
+		http.Error(w, "Only the owner can transfer the server", http.StatusForbidden)
+		return
+	}
+
+	// Ask the owner to confirm with their password
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(requestData.Password)); err != nil {
+		http.Error(w, "Invalid password", http.StatusForbidden)
+		return
+	}
+
+	if requestData.UserID == user.ID {
+		http.Error(w, "You already own this server", http.StatusBadRequest)
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(server.ID, requestData.UserID)
+	if err != nil || !isMember {
+		http.Error(w, "User is not a member of this server", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.serverService.TransferOwnership(server, requestData.UserID); err != nil {
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+	ws.BroadcastServerUpdate(server)
+
+	changes := entity.AuditLogChanges{}
+	changes.Set("owner", user.ID, requestData.UserID)
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   server.ID,
+		ActorID:    user.ID,
+		Action:     entity.AuditServerTransfer,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   requestData.UserID,
+		Changes:    changes,
+		Reason:     auditLogReason(r),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(server.ToResponse())
+}
+
+// LeaveServer removes the user from a server and all of its channels
+func (c *ServerController) LeaveServer(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId := vars["id"]
+
+	server, err := c.serverService.GetServer(serverId)
+	if err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+
+	if server.UserID == user.ID {
+		http.Error(w, "The owner must transfer the server before leaving", http.StatusConflict)
+		return
+	}
+
+	isMember, err := c.permissionService.IsServerMember(server.ID, user.ID)
+	if err != nil || !isMember {
+		http.Error(w, "You are not a member of this server", http.StatusNotFound)
+		return
+	}
+
+	if err := c.serverService.RemoveUserFromServer(server.ID, user.ID); err != nil {
+		http.Error(w, "Failed to leave server", http.StatusInternalServerError)
+		return
+	}
+
+	// Make the other sessions of the user drop the server
+	channels, err := c.channelService.GetServerChannels(serverId)
+	if err == nil {
+		channelIDs := make([]uint, len(channels))
+		for i, channel := range channels {
+			channelIDs[i] = channel.ID
+		}
+		ws.RemoveFromServer(user.ID, server.ID, channelIDs, "leave")
+	}
+
+	c.auditLogService.Record(&entity.AuditLogEntry{
+		ServerID:   server.ID,
+		ActorID:    user.ID,
+		Action:     entity.AuditMemberLeave,
+		TargetType: entity.AuditTargetMember,
+		TargetID:   user.ID,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Left server successfully",
+	})
+}
+
+// SetNickname sets the nickname of the current user in a server, an empty nickname clears it
+func (c *ServerController) SetNickname(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		Nickname string `json:"nickname"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Nickname = strings.TrimSpace(input.Nickname)
+
+	if err := c.serverService.SetNickname(uint(serverId), user.ID, input.Nickname); err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			http.Error(w, "You are not a member of this server", http.StatusNotFound)
+		case services.ErrNicknameTooLong:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to set nickname", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ws.BroadcastMemberUpdate(c.serverService.DB, uint(serverId), user, input.Nickname)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"nickname": input.Nickname,
+	})
+}
@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
+)
+
+type ThreadController struct {
+	threadService     *services.ThreadService
+	messageService    *services.MessageService
+	permissionService *services.PermissionService
+}
+
+func NewThreadController(threadService *services.ThreadService, messageService *services.MessageService, permissionService *services.PermissionService) *ThreadController {
+	return &ThreadController{
+		threadService:     threadService,
+		messageService:    messageService,
+		permissionService: permissionService,
+	}
+}
+
+// CreateThread starts a thread from a message
+func (c *ThreadController) CreateThread(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	messageId := vars["id"]
+
+	var input struct {
+		Name                string `json:"name"`
+		AutoArchiveDuration int    `json:"autoArchiveDuration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	message, err := c.messageService.GetMessage(messageId)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	allowed, err := c.permissionService.HasChannelPermission(message.ChannelID, user.ID, entity.PermissionViewChannel|entity.PermissionSendMessages)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to create threads in this channel", http.StatusForbidden)
+		return
+	}
+
+	thread, err := c.threadService.CreateThread(message, user.ID, input.Name, input.AutoArchiveDuration)
+	if err != nil {
+		switch err {
+		case services.ErrThreadExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case services.ErrNestedThread, services.ErrInvalidArchive:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to create thread", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ws.BroadcastThread(c.threadService.DB, "THREAD_CREATE", thread)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(thread.ToResponse())
+}
+
+// GetChannelThreads returns the threads of a channel, active ones first
+func (c *ThreadController) GetChannelThreads(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	channelId, err := strconv.ParseUint(vars["channelID"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel ID", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(uint(channelId), user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+
+	threads, err := c.threadService.GetChannelThreads(uint(channelId))
+	if err != nil {
+		http.Error(w, "Failed to fetch threads", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(threads)
+}
+
+// GetThread returns a thread by ID
+func (c *ThreadController) GetThread(w http.ResponseWriter, r *http.Request) {
+	thread, _, ok := c.getAccessibleThread(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(thread.ToResponse())
+}
+
+// UpdateThread renames, archives or unarchives a thread, its creator and channel managers can update it
+func (c *ThreadController) UpdateThread(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	threadId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		Name                *string `json:"name"`
+		Archived            *bool   `json:"archived"`
+		AutoArchiveDuration *int    `json:"autoArchiveDuration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	thread, err := c.threadService.GetThread(uint(threadId))
+	if err != nil {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(thread.ChannelID, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return
+	}
+	if thread.UserID != user.ID {
+		allowed, err := c.permissionService.HasChannelPermission(thread.ChannelID, user.ID, entity.PermissionManageChannels)
+		if err != nil || !allowed {
+			http.Error(w, "You are not allowed to update this thread", http.StatusForbidden)
+			return
+		}
+	}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		thread.Name = name
+	}
+	if input.Archived != nil {
+		thread.Archived = *input.Archived
+	}
+	if input.AutoArchiveDuration != nil {
+		thread.AutoArchiveDuration = *input.AutoArchiveDuration
+	}
+
+	if err := c.threadService.UpdateThread(thread); err != nil {
+		if err == services.ErrInvalidArchive {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to update thread", http.StatusInternalServerError)
+		return
+	}
+
+	ws.BroadcastThread(c.threadService.DB, "THREAD_UPDATE", thread)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(thread.ToResponse())
+}
+
+// GetThreadMessages returns a page of messages in a thread, selected like channel messages
+func (c *ThreadController) GetThreadMessages(w http.ResponseWriter, r *http.Request) {
+	thread, user, ok := c.getAccessibleThread(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := parseMessagePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := c.messageService.GetThreadMessages(fmt.Sprintf("%d", thread.ID), user.ID, page)
+	if err != nil {
+		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(messages)
+}
+
+// GetThreadMembers returns the participants of a thread
+func (c *ThreadController) GetThreadMembers(w http.ResponseWriter, r *http.Request) {
+	thread, _, ok := c.getAccessibleThread(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := c.threadService.GetThreadMembers(thread.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch thread members", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(members)
+}
+
+// JoinThread adds the current user to the participants of a thread
+func (c *ThreadController) JoinThread(w http.ResponseWriter, r *http.Request) {
+	thread, user, ok := c.getAccessibleThread(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.threadService.AddThreadMember(thread.ID, user.ID); err != nil {
+		http.Error(w, "Failed to join thread", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Joined thread successfully",
+	})
+}
+
+// LeaveThread removes the current user from the participants of a thread
+func (c *ThreadController) LeaveThread(w http.ResponseWriter, r *http.Request) {
+	thread, user, ok := c.getAccessibleThread(w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.threadService.RemoveThreadMember(thread.ID, user.ID); err != nil {
+		http.Error(w, "Failed to leave thread", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Left thread successfully",
+	})
+}
+
+// getAccessibleThread loads the thread of the request, writing the error response
+// if the current user cannot read its parent channel
+func (c *ThreadController) getAccessibleThread(w http.ResponseWriter, r *http.Request) (*entity.Thread, *entity.User, bool) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	vars := mux.Vars(r)
+	threadId, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	thread, err := c.threadService.GetThread(uint(threadId))
+	if err != nil {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	allowed, err := c.permissionService.CanAccessChannel(thread.ChannelID, user.ID)
+	if err != nil || !allowed {
+		http.Error(w, "You are not allowed to access this channel", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return thread, user, true
+}
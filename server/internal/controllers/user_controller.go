@@ -2,20 +2,34 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/services"
+	"lesha.com/server/internal/ws"
 )
 
 type UserController struct {
-	userService *services.UserService
+	userService       *services.UserService
+	mentionService    *services.MentionService
+	friendshipService *services.FriendshipService
 }
 
-func NewUserController(userService *services.UserService) *UserController {
+func NewUserController(userService *services.UserService, mentionService *services.MentionService, friendshipService *services.FriendshipService) *UserController {
 	return &UserController{
-		userService: userService,
+		userService:       userService,
+		mentionService:    mentionService,
+		friendshipService: friendshipService,
 	}
 }
 
@@ -82,6 +96,8 @@ func (c *UserController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ws.BroadcastUserUpdate(c.userService.DB, existingUser)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -123,7 +139,268 @@ func (c *UserController) GetUserFriends(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	friends, err := c.friendshipService.GetFriends(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch friends", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(friends)
+}
+
+// GetMyMentions returns the recent messages mentioning the current user, newest first.
+// The before message ID and limit query parameters page through older mentions.
+func (c *UserController) GetMyMentions(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	var before uint64
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid before message ID", http.StatusBadRequest)
+			return
+		}
+	}
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	mentions, err := c.mentionService.GetUserMentions(user.ID, uint(before), limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch mentions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mentions)
+}
+
+// GetUserProfile returns the full profile of a user
+func (c *UserController) GetUserProfile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userId := vars["id"]
+
+	user, err := c.userService.GetUser(userId)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user.ToProfileResponse())
+}
+
+// UpdateProfile changes the bio and pronouns of the current user
+func (c *UserController) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Bio      *string `json:"bio"`
+		Pronouns *string `json:"pronouns"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := c.userService.UpdateProfile(user.ID, input.Bio, input.Pronouns)
+	if err != nil {
+		switch err {
+		case services.ErrBioTooLong, services.ErrPronounsTooLong:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ws.BroadcastUserUpdate(c.userService.DB, updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated.ToProfileResponse())
+}
+
+// UploadAvatar replaces the avatar of the current user with the uploaded image
+func (c *UserController) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	c.uploadProfileImage(w, r, "uploads/avatars", c.userService.SetAvatar)
+}
+
+// UploadBanner replaces the banner of the current user with the uploaded image
+func (c *UserController) UploadBanner(w http.ResponseWriter, r *http.Request) {
+	c.uploadProfileImage(w, r, "uploads/banners", c.userService.SetBanner)
+}
+
+// SetCustomStatus sets the custom status of the current user, with an optional expiry time
+func (c *UserController) SetCustomStatus(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Text      string     `json:"text"`
+		Emoji     string     `json:"emoji"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := c.userService.SetCustomStatus(user.ID, input.Text, input.Emoji, input.ExpiresAt)
+	if err != nil {
+		switch err {
+		case services.ErrCustomStatusTooLong, services.ErrStatusEmojiTooLong, services.ErrStatusExpired:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to set custom status", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ws.BroadcastUserUpdate(c.userService.DB, updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated.ToResponse())
+}
+
+// ClearCustomStatus removes the custom status of the current user
+func (c *UserController) ClearCustomStatus(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	updated, err := c.userService.SetCustomStatus(user.ID, "", "", nil)
+	if err != nil {
+		http.Error(w, "Failed to clear custom status", http.StatusInternalServerError)
+		return
+	}
+
+	ws.BroadcastUserUpdate(c.userService.DB, updated)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Custom status cleared successfully",
+	})
+}
+
+// uploadProfileImage saves the image of the multipart form to uploadDir and applies it to the current user
+func (c *UserController) uploadProfileImage(w http.ResponseWriter, r *http.Request, uploadDir string, update func(uint, string) (*entity.User, error)) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := services.ExtractUserFromToken(cookie.Value)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxProfileImageSize+1<<20)
+	if err := r.ParseMultipartForm(services.MaxProfileImageSize); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	file, handler, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Error retrieving image file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if handler.Size > services.MaxProfileImageSize {
+		http.Error(w, "Image is too large", http.StatusBadRequest)
+		return
+	}
+	switch strings.ToLower(filepath.Ext(handler.Filename)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+	default:
+		http.Error(w, "Unsupported image type", http.StatusBadRequest)
+		return
+	}
+
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		http.Error(w, "Failed to create upload directory", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("%d_%d%s", user.ID, time.Now().UnixNano(), strings.ToLower(filepath.Ext(handler.Filename)))
+	imagePath := path.Join(uploadDir, filename)
+
+	dst, err := os.Create(imagePath)
+	if err != nil {
+		http.Error(w, "Failed to create file", http.StatusInternalServerError)
+		return
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, file); err != nil {
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+
+	updated, err := update(user.ID, imagePath)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
+	ws.BroadcastUserUpdate(c.userService.DB, updated)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(user.Friends)
+	json.NewEncoder(w).Encode(updated.ToProfileResponse())
 }
@@ -0,0 +1,103 @@
+package entity
+
+import (
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditServerCreate     = "SERVER_CREATE"
+	AuditServerUpdate     = "SERVER_UPDATE"
+	AuditServerDelete     = "SERVER_DELETE"
+	AuditServerTransfer   = "SERVER_OWNER_TRANSFER"
+	AuditChannelCreate    = "CHANNEL_CREATE"
+	AuditChannelUpdate    = "CHANNEL_UPDATE"
+	AuditChannelDelete    = "CHANNEL_DELETE"
+	AuditOverwriteUpdate  = "CHANNEL_OVERWRITE_UPDATE"
+	AuditOverwriteDelete  = "CHANNEL_OVERWRITE_DELETE"
+	AuditMessagePin       = "MESSAGE_PIN"
+	AuditMessageUnpin     = "MESSAGE_UNPIN"
+	AuditMessageDelete    = "MESSAGE_DELETE"
+	AuditRoleCreate       = "ROLE_CREATE"
+	AuditRoleUpdate       = "ROLE_UPDATE"
+	AuditRoleDelete       = "ROLE_DELETE"
+	AuditMemberRoleAdd    = "MEMBER_ROLE_ADD"
+	AuditMemberRoleRemove = "MEMBER_ROLE_REMOVE"
+	AuditMemberAdd        = "MEMBER_ADD"
+	AuditMemberJoin       = "MEMBER_JOIN"
+	AuditMemberLeave      = "MEMBER_LEAVE"
+	AuditMemberKick       = "MEMBER_KICK"
+	AuditMemberBan        = "MEMBER_BAN"
+	AuditMemberUnban      = "MEMBER_UNBAN"
+	AuditMemberTimeout    = "MEMBER_TIMEOUT"
+	AuditInviteCreate     = "INVITE_CREATE"
+	AuditInviteDelete     = "INVITE_DELETE"
+)
+
+// Types of the object targeted by an audit log entry
+const (
+	AuditTargetServer  = "server"
+	AuditTargetChannel = "channel"
+	AuditTargetMessage = "message"
+	AuditTargetRole    = "role"
+	AuditTargetMember  = "member"
+	AuditTargetInvite  = "invite"
+)
+
+type AuditLogEntry struct {
+	gorm.Model
+	ServerID   uint   `gorm:"index:idx_audit_server_actor;index:idx_audit_server_action"`
+	ActorID    uint   `gorm:"index:idx_audit_server_actor"`
+	Actor      User   `gorm:"foreignKey:ActorID"`
+	Action     string `gorm:"index:idx_audit_server_action;size:32"`
+	TargetType string `gorm:"size:16"`
+	TargetID   uint
+	Changes    AuditLogChanges `gorm:"serializer:json;type:text"`
+	Reason     string
+}
+
+// AuditLogChange holds the value of a field before and after an action
+type AuditLogChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// AuditLogChanges maps a field name to its change
+type AuditLogChanges map[string]AuditLogChange
+
+// Set records the change of a field if the value actually changed
+func (changes AuditLogChanges) Set(field string, oldValue interface{}, newValue interface{}) {
+	if reflect.DeepEqual(oldValue, newValue) {
+		return
+	}
+	changes[field] = AuditLogChange{Old: oldValue, New: newValue}
+}
+
+// AuditLogEntryResponse represents the cleaned up audit log entry response
+type AuditLogEntryResponse struct {
+	ID         uint            `json:"id"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Actor      UserResponse    `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   uint            `json:"targetId"`
+	Changes    AuditLogChanges `json:"changes"`
+	Reason     string          `json:"reason"`
+}
+
+// ToResponse converts an AuditLogEntry to AuditLogEntryResponse
+func (e *AuditLogEntry) ToResponse() AuditLogEntryResponse {
+	return AuditLogEntryResponse{
+		ID:         e.ID,
+		CreatedAt:  e.CreatedAt,
+		Actor:      e.Actor.ToResponse(),
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		Changes:    e.Changes,
+		Reason:     e.Reason,
+	}
+}
@@ -4,21 +4,150 @@ import "time"
 
 // MessageResponse represents the cleaned up message response
 type MessageResponse struct {
-	ID        uint               `json:"id"`
-	CreatedAt time.Time          `json:"createdAt"`
-	User      UserResponse       `json:"user"`
-	Reactions []ReactionResponse `json:"reactions"`
-	Medias    []MediaResponse    `json:"medias"`
-	ChannelID uint               `json:"channelId"`
-	Content   string             `json:"content"`
-	Pinned    bool               `json:"pinned"`
+	ID            uint               `json:"id"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	User          UserResponse       `json:"user"`
+	Reactions     []ReactionResponse `json:"reactions"`
+	Medias        []MediaResponse    `json:"medias"`
+	ChannelID     uint               `json:"channelId"`
+	Content       string             `json:"content"`
+	Pinned        bool               `json:"pinned"`
+	PinnedAt      *time.Time         `json:"pinnedAt"`
+	PinnedBy      *UserResponse      `json:"pinnedBy"` // Only set in the pins of a channel
+	EditedAt      *time.Time         `json:"editedAt"`
+	RevisionCount int                `json:"revisionCount"`
+	ThreadID      *uint              `json:"threadId"`
+	Mentions      []MentionResponse  `json:"mentions"`
+	Blocked       bool               `json:"blocked"` // The author is blocked by the current user, the content is hidden
+
+	ReferencedMessage *MessageReferenceResponse `json:"referencedMessage"`
+}
+
+// MessageSearchResponse represents a page of search results
+type MessageSearchResponse struct {
+	TotalResults int               `json:"totalResults"`
+	Messages     []MessageResponse `json:"messages"`
+}
+
+// MentionResponse represents a user, role, channel or @everyone mentioned in a message
+type MentionResponse struct {
+	Type string `json:"type"`
+	ID   uint   `json:"id"`
+}
+
+// DirectMessageChannelResponse represents a direct message channel with the read state of the current user
+type DirectMessageChannelResponse struct {
+	ID                uint           `json:"id"`
+	Type              string         `json:"type"`
+	Name              string         `json:"name"`
+	Recipients        []UserResponse `json:"recipients"`
+	LastReadMessageID uint           `json:"lastReadMessageId"`
+	UnreadCount       int            `json:"unreadCount"`
+	MentionCount      int            `json:"mentionCount"`
+}
+
+// FriendshipResponse represents a relationship as seen by one of its users
+type FriendshipResponse struct {
+	ID        uint         `json:"id"`
+	User      UserResponse `json:"user"`
+	Status    string       `json:"status"`
+	Incoming  bool         `json:"incoming"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+// MessageReferenceResponse represents the quoted preview of the message replied to
+type MessageReferenceResponse struct {
+	ID      uint         `json:"id"`
+	User    UserResponse `json:"user"`
+	Content string       `json:"content"`
+}
+
+// ThreadResponse represents the cleaned up thread response
+type ThreadResponse struct {
+	ID                  uint         `json:"id"`
+	ChannelID           uint         `json:"channelId"`
+	StarterMessageID    uint         `json:"starterMessageId"`
+	User                UserResponse `json:"user"`
+	Name                string       `json:"name"`
+	Archived            bool         `json:"archived"`
+	AutoArchiveDuration int          `json:"autoArchiveDuration"`
+	LastMessageAt       time.Time    `json:"lastMessageAt"`
+	MessageCount        int          `json:"messageCount"`
+}
+
+// MessageRevisionResponse represents the cleaned up message revision response
+type MessageRevisionResponse struct {
+	ID         uint      `json:"id"`
+	MessageID  uint      `json:"messageId"`
+	Content    string    `json:"content"`
+	ReplacedAt time.Time `json:"replacedAt"`
 }
 
 // UserResponse represents the cleaned up user response
 type UserResponse struct {
-	ID          uint   `json:"id"`
-	Name        string `json:"name"`
-	DisplayName string `json:"displayName"`
+	ID           uint                  `json:"id"`
+	Name         string                `json:"name"`
+	DisplayName  string                `json:"displayName"`
+	Avatar       string                `json:"avatar"`
+	Nickname     string                `json:"nickname,omitempty"` // Set when the response is about a server
+	CustomStatus *CustomStatusResponse `json:"customStatus"`
+}
+
+// CustomStatusResponse represents the custom status of a user
+type CustomStatusResponse struct {
+	Text      string     `json:"text"`
+	Emoji     string     `json:"emoji"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// ProfileResponse represents the full profile of a user
+type ProfileResponse struct {
+	UserResponse
+	Banner   string `json:"banner"`
+	Bio      string `json:"bio"`
+	Pronouns string `json:"pronouns"`
+}
+
+// PresenceResponse represents the presence of a user as seen by other users
+type PresenceResponse struct {
+	UserID     uint       `json:"userId"`
+	Status     string     `json:"status"`
+	LastSeenAt *time.Time `json:"lastSeenAt"`
+}
+
+// ServerResponse represents the cleaned up server response
+type ServerResponse struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	OwnerID     uint      `json:"ownerId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// InviteResponse represents the cleaned up invite response
+type InviteResponse struct {
+	ID        uint          `json:"id"`
+	Code      string        `json:"code"`
+	ServerID  uint          `json:"serverId"`
+	Inviter   *UserResponse `json:"inviter,omitempty"`
+	MaxUses   int           `json:"maxUses"`
+	Uses      int           `json:"uses"`
+	ExpiresAt *time.Time    `json:"expiresAt"`
+	Temporary bool          `json:"temporary"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// BanResponse represents the cleaned up ban response
+type BanResponse struct {
+	ID          uint          `json:"id"`
+	ServerID    uint          `json:"serverId"`
+	UserID      uint          `json:"userId"`
+	User        *UserResponse `json:"user,omitempty"`
+	ModeratorID uint          `json:"moderatorId"`
+	Reason      string        `json:"reason"`
+	ExpiresAt   *time.Time    `json:"expiresAt"`
+	CreatedAt   time.Time     `json:"createdAt"`
 }
 
 // ReactionResponse represents the cleaned up reaction response
@@ -57,18 +186,109 @@ func (m *Message) ToResponse() MessageResponse {
 		}
 	}
 
+	mentions := make([]MentionResponse, len(m.Mentions))
+	for i, mention := range m.Mentions {
+		mentions[i] = MentionResponse{
+			Type: mention.Type,
+			ID:   mention.TargetID,
+		}
+	}
+
+	var referencedMessage *MessageReferenceResponse
+	if m.ReferencedMessage != nil {
+		referencedMessage = &MessageReferenceResponse{
+			ID:      m.ReferencedMessage.ID,
+			User:    m.ReferencedMessage.User.ToResponse(),
+			Content: m.ReferencedMessage.Content,
+		}
+	}
+
+	var pinnedBy *UserResponse
+	if m.PinnedBy != nil {
+		response := m.PinnedBy.ToResponse()
+		pinnedBy = &response
+	}
+
 	return MessageResponse{
-		ID:        m.ID,
-		CreatedAt: m.CreatedAt,
-		User: UserResponse{
-			ID:          m.User.ID,
-			Name:        m.User.Name,
-			DisplayName: m.User.DisplayName,
-		},
-		Reactions: reactions,
-		Medias:    medias,
-		ChannelID: m.ChannelID,
-		Content:   m.Content,
-		Pinned:    m.Pinned,
+		ID:            m.ID,
+		CreatedAt:     m.CreatedAt,
+		User:          m.User.ToResponse(),
+		Reactions:     reactions,
+		Medias:        medias,
+		ChannelID:     m.ChannelID,
+		Content:       m.Content,
+		Pinned:        m.Pinned,
+		PinnedAt:      m.PinnedAt,
+		EditedAt:      m.EditedAt,
+		RevisionCount: m.RevisionCount,
+		ThreadID:      m.ThreadID,
+		Mentions:      mentions,
+		PinnedBy:      pinnedBy,
+
+		ReferencedMessage: referencedMessage,
+	}
+}
+
+// ToResponse converts a Friendship to FriendshipResponse as seen by viewerID
+func (f *Friendship) ToResponse(viewerID uint) FriendshipResponse {
+	other := f.Other(viewerID)
+	return FriendshipResponse{
+		ID:        f.ID,
+		User:      other.ToResponse(),
+		Status:    f.Status,
+		Incoming:  f.FriendID == viewerID,
+		CreatedAt: f.CreatedAt,
+	}
+}
+
+// ToResponse converts a Thread to ThreadResponse
+func (t *Thread) ToResponse() ThreadResponse {
+	return ThreadResponse{
+		ID:                  t.ID,
+		ChannelID:           t.ChannelID,
+		StarterMessageID:    t.StarterMessageID,
+		User:                t.User.ToResponse(),
+		Name:                t.Name,
+		Archived:            t.Archived,
+		AutoArchiveDuration: t.AutoArchiveDuration,
+		LastMessageAt:       t.LastMessageAt,
+		MessageCount:        t.MessageCount,
+	}
+}
+
+// ToResponse converts an Invite to InviteResponse, the inviter is only set when it was loaded
+func (i *Invite) ToResponse() InviteResponse {
+	response := InviteResponse{
+		ID:        i.ID,
+		Code:      i.Code,
+		ServerID:  i.ServerID,
+		MaxUses:   i.MaxUses,
+		Uses:      i.Uses,
+		ExpiresAt: i.ExpiresAt,
+		Temporary: i.Temporary,
+		CreatedAt: i.CreatedAt,
+	}
+	if i.User.ID != 0 {
+		inviter := i.User.ToResponse()
+		response.Inviter = &inviter
+	}
+	return response
+}
+
+// ToResponse converts a Ban to BanResponse, the banned user is only set when it was loaded
+func (b *Ban) ToResponse() BanResponse {
+	response := BanResponse{
+		ID:          b.ID,
+		ServerID:    b.ServerID,
+		UserID:      b.UserID,
+		ModeratorID: b.ModeratorID,
+		Reason:      b.Reason,
+		ExpiresAt:   b.ExpiresAt,
+		CreatedAt:   b.CreatedAt,
+	}
+	if b.User.ID != 0 {
+		user := b.User.ToResponse()
+		response.User = &user
 	}
+	return response
 }
@@ -1,6 +1,10 @@
 package entity
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Server struct {
 	gorm.Model
@@ -12,12 +16,106 @@ type Server struct {
 	User        User
 }
 
+// ToResponse converts a server to ServerResponse
+func (s *Server) ToResponse() ServerResponse {
+	return ServerResponse{
+		ID:          s.ID,
+		Name:        s.Name,
+		Description: s.Description,
+		Image:       s.Image,
+		OwnerID:     s.UserID,
+		CreatedAt:   s.CreatedAt,
+	}
+}
+
+type Role struct {
+	gorm.Model
+	ServerID    uint
+	Server      Server
+	Name        string
+	Permissions int64
+	Position    int
+}
+
+type MemberRole struct {
+	gorm.Model
+	ServerID uint `gorm:"uniqueIndex:idx_member_role"`
+	UserID   uint `gorm:"uniqueIndex:idx_member_role"`
+	RoleID   uint `gorm:"uniqueIndex:idx_member_role"`
+	Role     Role `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// UserServer is the join table between users and the servers they are members of
+type UserServer struct {
+	UserID       uint `gorm:"primaryKey"`
+	ServerID     uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	Temporary    bool       // Temporary members are removed when they disconnect
+	TimeoutUntil *time.Time // Timed out members cannot send messages until then
+	Nickname     string     `gorm:"size:32"` // Shown instead of the display name in the server
+}
+
+type Ban struct {
+	gorm.Model
+	ServerID    uint `gorm:"uniqueIndex:idx_server_ban"`
+	UserID      uint `gorm:"uniqueIndex:idx_server_ban"`
+	User        User
+	ModeratorID uint
+	Reason      string
+	ExpiresAt   *time.Time // nil means the ban is permanent
+}
+
+type Invite struct {
+	gorm.Model
+	Code      string `gorm:"uniqueIndex;size:16"`
+	ServerID  uint
+	Server    Server
+	UserID    uint
+	User      User
+	MaxUses   int // 0 means unlimited
+	Uses      int
+	ExpiresAt *time.Time
+	Temporary bool
+}
+
+const (
+	ChannelTypeText    = "text"
+	ChannelTypeDM      = "dm"
+	ChannelTypeGroupDM = "group_dm"
+)
+
 type Channel struct {
 	gorm.Model
-	ServerID uint
-	Server   Server
-	Messages []Message
-	Name     string
+	ServerID   *uint // nil for direct message channels
+	Server     Server
+	Type       string `gorm:"default:text"`
+	Messages   []Message
+	Overwrites []ChannelOverwrite `gorm:"constraint:OnDelete:CASCADE;"`
+	Name       string
+	Recipients []User  `gorm:"many2many:channel_recipients;"` // Members of a direct message channel
+	DMKey      *string `gorm:"uniqueIndex;size:64"`           // Identifies the direct message channel between two users
+}
+
+// IsDirect reports whether the channel is a direct message channel outside of any server
+func (c *Channel) IsDirect() bool {
+	return c.ServerID == nil
+}
+
+// GetServerID returns the server of the channel, or 0 for direct message channels
+func (c *Channel) GetServerID() uint {
+	if c.ServerID == nil {
+		return 0
+	}
+	return *c.ServerID
+}
+
+type ChannelOverwrite struct {
+	gorm.Model
+	ChannelID  uint   `gorm:"uniqueIndex:idx_channel_overwrite"`
+	TargetType string `gorm:"uniqueIndex:idx_channel_overwrite;size:16"` // Can be "everyone", "role", "member"
+	TargetID   uint   `gorm:"uniqueIndex:idx_channel_overwrite"`
+	Allow      int64
+	Deny       int64
 }
 
 type User struct {
@@ -31,28 +129,167 @@ type User struct {
 	DisplayName string
 	Email       string `gorm:"unique"`
 	Password    string
-	Status      bool
+	Status      string     `gorm:"size:16;default:online"` // Status chosen by the user: online, idle, dnd or invisible
+	LastSeenAt  *time.Time // Last time the user closed their last connection
+
+	Avatar                string     `gorm:"size:255"`
+	Banner                string     `gorm:"size:255"`
+	Bio                   string     `gorm:"size:190"`
+	Pronouns              string     `gorm:"size:40"`
+	CustomStatusText      string     `gorm:"size:128"`
+	CustomStatusEmoji     string     `gorm:"size:64"`
+	CustomStatusExpiresAt *time.Time // nil means the custom status does not expire
 }
 
+// ToResponse converts a User to UserResponse, leaving out the custom status once expired
+func (u *User) ToResponse() UserResponse {
+	response := UserResponse{
+		ID:          u.ID,
+		Name:        u.Name,
+		DisplayName: u.DisplayName,
+		Avatar:      u.Avatar,
+	}
+	expired := u.CustomStatusExpiresAt != nil && !u.CustomStatusExpiresAt.After(time.Now())
+	if (u.CustomStatusText != "" || u.CustomStatusEmoji != "") && !expired {
+		response.CustomStatus = &CustomStatusResponse{
+			Text:      u.CustomStatusText,
+			Emoji:     u.CustomStatusEmoji,
+			ExpiresAt: u.CustomStatusExpiresAt,
+		}
+	}
+	return response
+}
+
+// ToProfileResponse converts a User to ProfileResponse
+func (u *User) ToProfileResponse() ProfileResponse {
+	return ProfileResponse{
+		UserResponse: u.ToResponse(),
+		Banner:       u.Banner,
+		Bio:          u.Bio,
+		Pronouns:     u.Pronouns,
+	}
+}
+
+const (
+	PresenceOnline    = "online"
+	PresenceIdle      = "idle"
+	PresenceDND       = "dnd"
+	PresenceInvisible = "invisible" // Chosen by the user, seen as offline by other users
+	PresenceOffline   = "offline"
+)
+
+// IsValidStatus reports whether a user can choose the status
+func IsValidStatus(status string) bool {
+	switch status {
+	case PresenceOnline, PresenceIdle, PresenceDND, PresenceInvisible:
+		return true
+	}
+	return false
+}
+
+const (
+	FriendshipPending  = "pending"
+	FriendshipAccepted = "accepted"
+	FriendshipBlocked  = "blocked"
+	FriendshipNone     = "none" // Status of a relationship once it has been deleted
+)
+
+// Friendship is the single relationship between two users, UserID is the user who sent the request.
+// Blocks are directional instead: UserID blocked FriendID, and both users can block each other.
 type Friendship struct {
 	gorm.Model
 	UserID   uint
 	FriendID uint
-	Status   string `gorm:"default:'pending'"` // Can be "accepted", "pending", "blocked"
-	User     User   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
-	Friend   User   `gorm:"foreignKey:FriendID;constraint:OnDelete:CASCADE;"`
+	Status   string  `gorm:"default:'pending'"`   // Can be "accepted", "pending", "blocked"
+	PairKey  *string `gorm:"uniqueIndex;size:64"` // Same for both directions, so a pair has one relationship
+	User     User    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	Friend   User    `gorm:"foreignKey:FriendID;constraint:OnDelete:CASCADE;"`
+}
+
+// Other returns the user of the friendship who is not userID
+func (f *Friendship) Other(userID uint) User {
+	if f.UserID == userID {
+		return f.Friend
+	}
+	return f.User
 }
 
 type Message struct {
 	gorm.Model
-	UserID    uint
-	User      User
-	Reactions []Reaction `gorm:"constraint:OnDelete:CASCADE;"`
-	Medias    []Media    `gorm:"constraint:OnDelete:CASCADE;"`
-	ChannelID uint
-	Channel   Channel
+	UserID        uint
+	User          User
+	Reactions     []Reaction `gorm:"constraint:OnDelete:CASCADE;"`
+	Medias        []Media    `gorm:"constraint:OnDelete:CASCADE;"`
+	ChannelID     uint       `gorm:"index:idx_message_history,priority:1"` // Together with the primary key, backs the paginated history queries
+	Channel       Channel
+	ThreadID      *uint `gorm:"index;index:idx_message_history,priority:2"` // Set for messages posted in a thread
+	Content       string
+	Pinned        bool
+	PinnedAt      *time.Time `gorm:"index"`
+	PinnedByID    *uint
+	PinnedBy      *User `gorm:"foreignKey:PinnedByID"`
+	EditedAt      *time.Time
+	RevisionCount int
+	Revisions     []MessageRevision `gorm:"constraint:OnDelete:CASCADE;"`
+
+	ReferencedMessageID *uint
+	ReferencedMessage   *Message `gorm:"foreignKey:ReferencedMessageID"`
+
+	Mentions       []Mention     `gorm:"constraint:OnDelete:CASCADE;"`
+	MentionedUsers []UserMention `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+const (
+	MentionUser     = "user"
+	MentionRole     = "role"
+	MentionEveryone = "everyone"
+	MentionChannel  = "channel"
+)
+
+// Mention is a user, role, channel or @everyone referenced in the content of a message
+type Mention struct {
+	gorm.Model
+	MessageID uint   `gorm:"index"`
+	Type      string // MentionUser, MentionRole, MentionEveryone or MentionChannel
+	TargetID  uint   // 0 for MentionEveryone
+}
+
+// UserMention is a user notified by a message, resolved from its mentions
+type UserMention struct {
+	gorm.Model
+	UserID    uint `gorm:"index:idx_user_mention,priority:1"`
+	ChannelID uint `gorm:"index:idx_user_mention,priority:2"`
+	MessageID uint `gorm:"index"`
+	Message   Message
+}
+
+// MessageTerm is a word of the content of a message, stored for the database search index
+type MessageTerm struct {
+	MessageID uint   `gorm:"primaryKey;autoIncrement:false"`
+	Term      string `gorm:"primaryKey;size:64;index"`
+}
+
+// Thread is a conversation spawned from a message, with its own message stream
+type Thread struct {
+	gorm.Model
+	ChannelID           uint `gorm:"index"`
+	Channel             Channel
+	StarterMessageID    uint `gorm:"uniqueIndex"`
+	UserID              uint
+	User                User
+	Name                string
+	Archived            bool
+	AutoArchiveDuration int // Minutes of inactivity before the thread is archived
+	LastMessageAt       time.Time
+	MessageCount        int
+	Members             []User `gorm:"many2many:thread_members;"`
+}
+
+// MessageRevision keeps the content a message had before it was edited
+type MessageRevision struct {
+	gorm.Model
+	MessageID uint `gorm:"index"`
 	Content   string
-	Pinned    bool
 }
 
 type Reaction struct {
@@ -0,0 +1,41 @@
+package entity
+
+// Permission bits that can be granted to a role
+const (
+	PermissionManageServer int64 = 1 << iota
+	PermissionManageChannels
+	PermissionPinMessages
+	PermissionKickMembers
+	PermissionBanMembers
+	PermissionManageRoles
+	PermissionSendMessages
+	PermissionAttachFiles
+	PermissionViewChannel
+	PermissionModerateMembers
+	PermissionViewAuditLog
+	PermissionManageMessages
+	PermissionMentionEveryone
+)
+
+// PermissionAll is granted to the owner of a server
+const PermissionAll = PermissionManageServer | PermissionManageChannels | PermissionPinMessages |
+	PermissionKickMembers | PermissionBanMembers | PermissionManageRoles |
+	PermissionSendMessages | PermissionAttachFiles | PermissionViewChannel |
+	PermissionModerateMembers | PermissionViewAuditLog | PermissionManageMessages |
+	PermissionMentionEveryone
+
+// DirectMessagePermissions is granted to the recipients of a direct message channel
+const DirectMessagePermissions = PermissionSendMessages | PermissionAttachFiles | PermissionViewChannel | PermissionPinMessages
+
+// DefaultPermissions is granted to every member of a server regardless of roles
+const DefaultPermissions = PermissionSendMessages | PermissionAttachFiles | PermissionViewChannel
+
+// TimeoutRevokedPermissions are removed from members while they are timed out
+const TimeoutRevokedPermissions = PermissionSendMessages | PermissionAttachFiles
+
+// Targets of a channel permission overwrite
+const (
+	OverwriteEveryone = "everyone"
+	OverwriteRole     = "role"
+	OverwriteMember   = "member"
+)
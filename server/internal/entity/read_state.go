@@ -0,0 +1,51 @@
+package entity
+
+import "gorm.io/gorm"
+
+// ReadState is the last message a user has read in a channel
+type ReadState struct {
+	gorm.Model
+	UserID        uint `gorm:"uniqueIndex:idx_read_state"`
+	ChannelID     uint `gorm:"uniqueIndex:idx_read_state"`
+	LastMessageID uint
+}
+
+// ChannelWithReadState is a channel along with the read state of the current user
+type ChannelWithReadState struct {
+	Channel
+	LastReadMessageID uint `json:"lastReadMessageId"`
+	UnreadCount       int  `json:"unreadCount"`
+	MentionCount      int  `json:"mentionCount"`
+}
+
+// ServerWithReadState is a server along with the unread messages and mentions
+// of the current user in the channels they can view
+type ServerWithReadState struct {
+	Server
+	UnreadCount  int `json:"unreadCount"`
+	MentionCount int `json:"mentionCount"`
+}
+
+// ServerWithChannels is a server along with the channels the current user can view and their read state
+type ServerWithChannels struct {
+	ServerWithReadState
+	Channels []ChannelWithReadState `json:"Channels"`
+}
+
+// ToDirectMessageResponse converts a direct message channel to DirectMessageChannelResponse
+func (c *ChannelWithReadState) ToDirectMessageResponse() DirectMessageChannelResponse {
+	recipients := make([]UserResponse, len(c.Recipients))
+	for i, recipient := range c.Recipients {
+		recipients[i] = recipient.ToResponse()
+	}
+
+	return DirectMessageChannelResponse{
+		ID:                c.ID,
+		Type:              c.Type,
+		Name:              c.Name,
+		Recipients:        recipients,
+		LastReadMessageID: c.LastReadMessageID,
+		UnreadCount:       c.UnreadCount,
+		MentionCount:      c.MentionCount,
+	}
+}
@@ -0,0 +1,46 @@
+// repositories/audit_log_repository.go
+package repositories
+
+import (
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+type AuditLogRepository struct {
+	DB *gorm.DB
+}
+
+// AuditLogFilter narrows down the entries of a server audit log.
+// Zero values are ignored, Before is the ID of the oldest entry already fetched.
+type AuditLogFilter struct {
+	ActorID uint
+	Action  string
+	Before  uint
+	Limit   int
+}
+
+func NewAuditLogRepository(db *gorm.DB) *AuditLogRepository {
+	return &AuditLogRepository{DB: db}
+}
+
+func (repo *AuditLogRepository) CreateEntry(entry *entity.AuditLogEntry) error {
+	return repo.DB.Create(entry).Error
+}
+func (repo *AuditLogRepository) GetServerEntries(serverID uint, filter AuditLogFilter) ([]entity.AuditLogEntry, error) {
+	var entries []entity.AuditLogEntry
+	query := repo.DB.Where("server_id = ?", serverID)
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Before != 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+	err := query.Preload("Actor").Order("id DESC").Limit(filter.Limit).Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,57 @@
+// repositories/ban_repository.go
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+type BanRepository struct {
+	DB *gorm.DB
+}
+
+func NewBanRepository(db *gorm.DB) *BanRepository {
+	return &BanRepository{DB: db}
+}
+
+// SaveBan creates the ban or replaces the existing ban of the user in the server
+func (repo *BanRepository) SaveBan(ban *entity.Ban) error {
+	var existing entity.Ban
+	err := repo.DB.Where("server_id = ? AND user_id = ?", ban.ServerID, ban.UserID).First(&existing).Error
+	if err == nil {
+		ban.Model = existing.Model
+		return repo.DB.Save(ban).Error
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return repo.DB.Create(ban).Error
+}
+func (repo *BanRepository) GetActiveBan(serverID uint, userID uint) (*entity.Ban, error) {
+	var ban entity.Ban
+	err := repo.DB.Where("server_id = ? AND user_id = ?", serverID, userID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&ban).Error
+	if err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+func (repo *BanRepository) GetServerBans(serverId string) ([]entity.Ban, error) {
+	var bans []entity.Ban
+	err := repo.DB.Where("server_id = ?", serverId).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Preload("User").
+		Find(&bans).Error
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+func (repo *BanRepository) DeleteBan(serverID uint, userID uint) error {
+	return repo.DB.Unscoped().
+		Where("server_id = ? AND user_id = ?", serverID, userID).
+		Delete(&entity.Ban{}).Error
+}
@@ -3,6 +3,7 @@ package repositories
 
 import (
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"lesha.com/server/internal/entity"
 )
 
@@ -27,7 +28,7 @@ func (repo *ChannelRepository) GetChannel(channelId uint) (*entity.Channel, erro
 }
 func (repo *ChannelRepository) GetChannels() ([]entity.Channel, error) {
 	var channels []entity.Channel
-	err := repo.DB.Find(&channels).Error
+	err := repo.DB.Where("server_id IS NOT NULL AND type NOT IN ?", []string{entity.ChannelTypeDM, entity.ChannelTypeGroupDM}).Find(&channels).Error
 	if err != nil {
 		return nil, err
 	}
@@ -42,6 +43,12 @@ func (repo *ChannelRepository) DeleteChannel(channel *entity.Channel) error {
 func (repo *ChannelRepository) AddUserToChannel(channelID uint, userID uint) error {
 	return repo.DB.Exec("INSERT INTO user_channels (channel_id, user_id) VALUES (?, ?)", channelID, userID).Error
 }
+
+// LockChannel locks the row of a channel until the end of the transaction
+func (repo *ChannelRepository) LockChannel(channelID uint) error {
+	var channel entity.Channel
+	return repo.DB.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", channelID).First(&channel).Error
+}
 func (repo *ChannelRepository) GetServerChannels(serverId string) ([]entity.Channel, error) {
 	var channels []entity.Channel
 	err := repo.DB.Where("server_id = ?", serverId).Find(&channels).Error
@@ -50,3 +57,81 @@ func (repo *ChannelRepository) GetServerChannels(serverId string) ([]entity.Chan
 	}
 	return channels, nil
 }
+
+// Permission overwrites
+func (repo *ChannelRepository) GetChannelOverwrites(channelID uint) ([]entity.ChannelOverwrite, error) {
+	var overwrites []entity.ChannelOverwrite
+	err := repo.DB.Where("channel_id = ?", channelID).Find(&overwrites).Error
+	if err != nil {
+		return nil, err
+	}
+	return overwrites, nil
+}
+func (repo *ChannelRepository) GetServerOverwrites(serverID uint) ([]entity.ChannelOverwrite, error) {
+	var overwrites []entity.ChannelOverwrite
+	err := repo.DB.Joins("JOIN channels ON channels.id = channel_overwrites.channel_id AND channels.deleted_at IS NULL").
+		Where("channels.server_id = ?", serverID).
+		Find(&overwrites).Error
+	if err != nil {
+		return nil, err
+	}
+	return overwrites, nil
+}
+func (repo *ChannelRepository) SaveChannelOverwrite(overwrite *entity.ChannelOverwrite) error {
+	var existing entity.ChannelOverwrite
+	err := repo.DB.Where("channel_id = ? AND target_type = ? AND target_id = ?", overwrite.ChannelID, overwrite.TargetType, overwrite.TargetID).
+		First(&existing).Error
+	if err == nil {
+		overwrite.Model = existing.Model
+		return repo.DB.Save(overwrite).Error
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return repo.DB.Create(overwrite).Error
+}
+func (repo *ChannelRepository) DeleteChannelOverwrite(channelID uint, targetType string, targetID uint) error {
+	return repo.DB.Unscoped().
+		Where("channel_id = ? AND target_type = ? AND target_id = ?", channelID, targetType, targetID).
+		Delete(&entity.ChannelOverwrite{}).Error
+}
+
+// Direct messages
+func (repo *ChannelRepository) CreateDirectChannel(channel *entity.Channel) error {
+	// Only link the recipients, the users themselves already exist
+	return repo.DB.Omit("Recipients.*").Create(channel).Error
+}
+func (repo *ChannelRepository) GetChannelByDMKey(key string) (*entity.Channel, error) {
+	var channel entity.Channel
+	err := repo.DB.Where("dm_key = ?", key).Preload("Recipients").First(&channel).Error
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// GetUserDirectChannels returns the direct message channels of the user, most recently active first
+func (repo *ChannelRepository) GetUserDirectChannels(userID uint) ([]entity.Channel, error) {
+	var channels []entity.Channel
+	err := repo.DB.Joins("JOIN channel_recipients ON channel_recipients.channel_id = channels.id").
+		Joins("LEFT JOIN messages ON messages.channel_id = channels.id AND messages.deleted_at IS NULL").
+		Where("channel_recipients.user_id = ? AND channels.server_id IS NULL", userID).
+		Group("channels.id").
+		Order("COALESCE(MAX(messages.created_at), channels.created_at) DESC").
+		Preload("Recipients").
+		Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+func (repo *ChannelRepository) IsChannelRecipient(channelID uint, userID uint) (bool, error) {
+	var count int64
+	err := repo.DB.Table("channel_recipients").Where("channel_id = ? AND user_id = ?", channelID, userID).Count(&count).Error
+	return count > 0, err
+}
+func (repo *ChannelRepository) GetChannelRecipientIDs(channelID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Table("channel_recipients").Where("channel_id = ?", channelID).Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
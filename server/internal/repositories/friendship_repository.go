@@ -0,0 +1,79 @@
+// repositories/friendship_repository.go
+package repositories
+
+import (
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+type FriendshipRepository struct {
+	DB *gorm.DB
+}
+
+func NewFriendshipRepository(db *gorm.DB) *FriendshipRepository {
+	return &FriendshipRepository{DB: db}
+}
+
+func (repo *FriendshipRepository) CreateFriendship(friendship *entity.Friendship) error {
+	return repo.DB.Create(friendship).Error
+}
+func (repo *FriendshipRepository) GetFriendshipByPairKey(pairKey string) (*entity.Friendship, error) {
+	var friendship entity.Friendship
+	err := repo.DB.Where("pair_key = ?", pairKey).Preload("User").Preload("Friend").First(&friendship).Error
+	if err != nil {
+		return nil, err
+	}
+	return &friendship, nil
+}
+func (repo *FriendshipRepository) UpdateFriendshipStatus(friendship *entity.Friendship) error {
+	return repo.DB.Model(friendship).Select("user_id", "friend_id", "status").Updates(friendship).Error
+}
+func (repo *FriendshipRepository) DeleteFriendship(friendship *entity.Friendship) error {
+	// Hard delete so the pair key can be used again
+	return repo.DB.Unscoped().Delete(friendship).Error
+}
+
+// GetUserFriendships returns the relationships of the user with a status, in both directions
+func (repo *FriendshipRepository) GetUserFriendships(userID uint, status string) ([]entity.Friendship, error) {
+	var friendships []entity.Friendship
+	err := repo.DB.Where("(user_id = ? OR friend_id = ?) AND status = ?", userID, userID, status).
+		Preload("User").Preload("Friend").
+		Order("created_at DESC").
+		Find(&friendships).Error
+	if err != nil {
+		return nil, err
+	}
+	return friendships, nil
+}
+
+// Blocks
+func (repo *FriendshipRepository) GetBlockedUsers(userID uint) ([]entity.Friendship, error) {
+	var friendships []entity.Friendship
+	err := repo.DB.Where("user_id = ? AND status = ?", userID, entity.FriendshipBlocked).
+		Preload("User").Preload("Friend").
+		Order("created_at DESC").
+		Find(&friendships).Error
+	if err != nil {
+		return nil, err
+	}
+	return friendships, nil
+}
+func (repo *FriendshipRepository) IsBlocked(blockerID uint, blockedID uint) (bool, error) {
+	var count int64
+	err := repo.DB.Model(&entity.Friendship{}).
+		Where("user_id = ? AND friend_id = ? AND status = ?", blockerID, blockedID, entity.FriendshipBlocked).
+		Count(&count).Error
+	return count > 0, err
+}
+func (repo *FriendshipRepository) GetBlockedIDs(userID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Model(&entity.Friendship{}).Where("user_id = ? AND status = ?", userID, entity.FriendshipBlocked).
+		Pluck("friend_id", &userIDs).Error
+	return userIDs, err
+}
+func (repo *FriendshipRepository) GetBlockerIDs(userID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Model(&entity.Friendship{}).Where("friend_id = ? AND status = ?", userID, entity.FriendshipBlocked).
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
@@ -0,0 +1,46 @@
+// repositories/invite_repository.go
+package repositories
+
+import (
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+type InviteRepository struct {
+	DB *gorm.DB
+}
+
+func NewInviteRepository(db *gorm.DB) *InviteRepository {
+	return &InviteRepository{DB: db}
+}
+
+func (repo *InviteRepository) CreateInvite(invite *entity.Invite) error {
+	return repo.DB.Create(invite).Error
+}
+func (repo *InviteRepository) GetInviteByCode(code string) (*entity.Invite, error) {
+	var invite entity.Invite
+	err := repo.DB.Where("code = ?", code).Preload("Server").First(&invite).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+func (repo *InviteRepository) GetServerInvites(serverId string) ([]entity.Invite, error) {
+	var invites []entity.Invite
+	err := repo.DB.Where("server_id = ?", serverId).Preload("User").Order("created_at DESC").Find(&invites).Error
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+func (repo *InviteRepository) DeleteInvite(invite *entity.Invite) error {
+	return repo.DB.Delete(invite).Error
+}
+
+// UseInvite increments the use counter unless the invite already reached its limit
+func (repo *InviteRepository) UseInvite(invite *entity.Invite) (bool, error) {
+	result := repo.DB.Model(&entity.Invite{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", invite.ID).
+		Update("uses", gorm.Expr("uses + 1"))
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,79 @@
+// repositories/mention_repository.go
+package repositories
+
+import (
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+type MentionRepository struct {
+	DB *gorm.DB
+}
+
+func NewMentionRepository(db *gorm.DB) *MentionRepository {
+	return &MentionRepository{DB: db}
+}
+
+// Resolving mention tokens
+func (repo *MentionRepository) FindServerMemberByName(serverID uint, name string) (*entity.User, error) {
+	var user entity.User
+	err := repo.DB.Joins("JOIN user_servers ON users.id = user_servers.user_id").
+		Where("user_servers.server_id = ? AND users.name = ?", serverID, name).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+func (repo *MentionRepository) FindChannelRecipientByName(channelID uint, name string) (*entity.User, error) {
+	var user entity.User
+	err := repo.DB.Joins("JOIN channel_recipients ON users.id = channel_recipients.user_id").
+		Where("channel_recipients.channel_id = ? AND users.name = ?", channelID, name).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+func (repo *MentionRepository) FindServerRoleByName(serverID uint, name string) (*entity.Role, error) {
+	var role entity.Role
+	err := repo.DB.Where("server_id = ? AND name = ?", serverID, name).First(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+func (repo *MentionRepository) FindServerChannelByName(serverID uint, name string) (*entity.Channel, error) {
+	var channel entity.Channel
+	err := repo.DB.Where("server_id = ? AND name = ?", serverID, name).First(&channel).Error
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+func (repo *MentionRepository) GetRoleMemberIDs(roleID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Model(&entity.MemberRole{}).Where("role_id = ?", roleID).Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+func (repo *MentionRepository) GetServerMemberIDs(serverID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Model(&entity.UserServer{}).Where("server_id = ?", serverID).Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// GetUserMentions returns the latest messages mentioning the user, newest first
+func (repo *MentionRepository) GetUserMentions(userID uint, before uint, limit int) ([]entity.Message, error) {
+	var messages []entity.Message
+	query := repo.DB.Joins("JOIN user_mentions ON user_mentions.message_id = messages.id AND user_mentions.deleted_at IS NULL").
+		Where("user_mentions.user_id = ?", userID)
+	if before != 0 {
+		query = query.Where("messages.id < ?", before)
+	}
+	err := query.Preload("Medias").Preload("Reactions").Preload("User").Preload("Mentions").Preload("ReferencedMessage.User").
+		Order("messages.id DESC").Limit(limit).Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
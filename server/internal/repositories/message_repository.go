@@ -2,6 +2,8 @@
 package repositories
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 )
@@ -10,6 +12,30 @@ type MessageRepository struct {
 	DB *gorm.DB
 }
 
+// MessagePage selects a page of a channel history. At most one of Before, After
+// and Around is set, and the latest messages are returned when none is.
+type MessagePage struct {
+	Before uint
+	After  uint
+	Around uint
+	Limit  int
+}
+
+// MessageSearch selects the messages of some channels containing every term and matching
+// every filter that is set
+type MessageSearch struct {
+	Terms      []string
+	ChannelIDs []uint
+	AuthorIDs  []uint
+	MentionIDs []uint
+	Has        []string
+	Pinned     *bool
+	Before     *time.Time
+	After      *time.Time
+	Offset     int
+	Limit      int
+}
+
 func NewMessageRepository(db *gorm.DB) *MessageRepository {
 	return &MessageRepository{DB: db}
 }
@@ -20,27 +46,163 @@ func (repo *MessageRepository) CreateMessage(message *entity.Message) error {
 func (repo *MessageRepository) UpdateMessage(message *entity.Message) error {
 	return repo.DB.Save(message).Error
 }
+func (repo *MessageRepository) UpdateMessageContent(message *entity.Message) error {
+	return repo.DB.Model(message).Select("content", "edited_at", "revision_count").Updates(message).Error
+}
 func (repo *MessageRepository) DeleteMessage(message *entity.Message) error {
 	return repo.DB.Delete(message).Error
 }
 func (repo *MessageRepository) GetMessage(messageId string) (*entity.Message, error) {
 	var message entity.Message
-	err := repo.DB.Where("id = ?", messageId).Preload("Medias").Preload("Reactions").Preload("User").First(&message).Error
+	err := repo.DB.Where("id = ?", messageId).Preload("Medias").Preload("Reactions").Preload("User").Preload("Mentions").Preload("ReferencedMessage.User").First(&message).Error
 	return &message, err
 }
-func (repo *MessageRepository) GetChannelMessages(channelId string) ([]entity.Message, error) {
+func (repo *MessageRepository) GetMessagesByIDs(messageIDs []uint) ([]entity.Message, error) {
+	var messages []entity.Message
+	err := repo.DB.Where("id IN ?", messageIDs).Preload("Medias").Preload("Reactions").Preload("User").Preload("Mentions").Preload("ReferencedMessage.User").
+		Order("id DESC").Find(&messages).Error
+	return messages, err
+}
+func (repo *MessageRepository) FindMessagesInBatches(batchSize int, process func(messages []entity.Message) error) error {
+	var messages []entity.Message
+	return repo.DB.Preload("Medias").Preload("Mentions").FindInBatches(&messages, batchSize, func(tx *gorm.DB, batch int) error {
+		return process(messages)
+	}).Error
+}
+
+// FindUnindexedMessagesInBatches goes through the messages that have no search terms stored
+func (repo *MessageRepository) FindUnindexedMessagesInBatches(batchSize int, process func(messages []entity.Message) error) error {
+	var messages []entity.Message
+	return repo.DB.Where("NOT EXISTS (SELECT 1 FROM message_terms WHERE message_terms.message_id = messages.id)").
+		FindInBatches(&messages, batchSize, func(tx *gorm.DB, batch int) error {
+			return process(messages)
+		}).Error
+}
+
+// SetMessageTerms replaces the search terms stored for a message
+func (repo *MessageRepository) SetMessageTerms(messageID uint, terms []string) error {
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", messageID).Delete(&entity.MessageTerm{}).Error; err != nil {
+			return err
+		}
+		if len(terms) == 0 {
+			return nil
+		}
+		messageTerms := make([]entity.MessageTerm, len(terms))
+		for i, term := range terms {
+			messageTerms[i] = entity.MessageTerm{MessageID: messageID, Term: term}
+		}
+		return tx.Create(&messageTerms).Error
+	})
+}
+
+// SearchMessages returns the IDs of a page of the matching messages, newest first, and the number of matches
+func (repo *MessageRepository) SearchMessages(search MessageSearch) ([]uint, int64, error) {
+	query := repo.DB.Model(&entity.Message{}).Where("channel_id IN ?", search.ChannelIDs)
+	for _, term := range search.Terms {
+		terms := repo.DB.Model(&entity.MessageTerm{}).Select("message_id").Where("term = ?", term)
+		query = query.Where("id IN (?)", terms)
+	}
+	if len(search.AuthorIDs) > 0 {
+		query = query.Where("user_id IN ?", search.AuthorIDs)
+	}
+	for _, mentionID := range search.MentionIDs {
+		mentions := repo.DB.Model(&entity.Mention{}).Select("message_id").Where("type = ? AND target_id = ?", entity.MentionUser, mentionID)
+		query = query.Where("id IN (?)", mentions)
+	}
+	for _, mediaType := range search.Has {
+		medias := repo.DB.Model(&entity.Media{}).Select("message_id").Where("type = ?", mediaType)
+		query = query.Where("id IN (?)", medias)
+	}
+	if search.Pinned != nil {
+		query = query.Where("pinned = ?", *search.Pinned)
+	}
+	if search.Before != nil {
+		query = query.Where("created_at < ?", *search.Before)
+	}
+	if search.After != nil {
+		query = query.Where("created_at > ?", *search.After)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	messageIDs := []uint{}
+	query = query.Order("id DESC").Offset(search.Offset)
+	if search.Limit > 0 {
+		query = query.Limit(search.Limit)
+	}
+	err := query.Pluck("id", &messageIDs).Error
+	return messageIDs, total, err
+}
+func (repo *MessageRepository) GetChannelMessages(channelId string, page MessagePage) ([]entity.Message, error) {
+	// Messages posted in threads are not part of the channel history
+	return repo.getMessagePage(repo.DB.Where("channel_id = ? AND thread_id IS NULL", channelId), page)
+}
+func (repo *MessageRepository) GetThreadMessages(threadId string, page MessagePage) ([]entity.Message, error) {
+	return repo.getMessagePage(repo.DB.Where("thread_id = ?", threadId), page)
+}
+
+// getMessagePage fetches a page of the messages matching the query in chronological order
+func (repo *MessageRepository) getMessagePage(query *gorm.DB, page MessagePage) ([]entity.Message, error) {
 	var messages []entity.Message
 
-	err := repo.DB.Where("channel_id = ?", channelId).Preload("Medias").Preload("Reactions").Preload("User").Find(&messages).Error
+	query = query.Preload("Medias").Preload("Reactions").Preload("User").Preload("Mentions").Preload("ReferencedMessage.User")
+	switch {
+	case page.After != 0:
+		err := query.Where("id > ?", page.After).Order("id ASC").Limit(page.Limit).Find(&messages).Error
+		return messages, err
+	case page.Around != 0:
+		// Half of the page up to the message itself, the other half after it
+		var newer []entity.Message
+		err := query.Session(&gorm.Session{}).Where("id <= ?", page.Around).Order("id DESC").Limit((page.Limit + 1) / 2).Find(&messages).Error
+		if err != nil {
+			return nil, err
+		}
+		err = query.Session(&gorm.Session{}).Where("id > ?", page.Around).Order("id ASC").Limit(page.Limit / 2).Find(&newer).Error
+		if err != nil {
+			return nil, err
+		}
+		reverseMessages(messages)
+		return append(messages, newer...), nil
+	case page.Before != 0:
+		query = query.Where("id < ?", page.Before)
+	}
+
+	err := query.Order("id DESC").Limit(page.Limit).Find(&messages).Error
+	reverseMessages(messages)
 	return messages, err
 }
 
+// Revisions
+func (repo *MessageRepository) CreateRevision(revision *entity.MessageRevision) error {
+	return repo.DB.Create(revision).Error
+}
+func (repo *MessageRepository) GetRevisions(messageId string) ([]entity.MessageRevision, error) {
+	var revisions []entity.MessageRevision
+	err := repo.DB.Where("message_id = ?", messageId).Order("id ASC").Find(&revisions).Error
+	return revisions, err
+}
+
 // Pin message
 func (repo *MessageRepository) PinMessage(message *entity.Message) error {
-	return repo.DB.Model(message).Update("pinned", true).Error
+	return repo.DB.Model(message).Select("pinned", "pinned_at", "pinned_by_id").Updates(message).Error
 }
 func (repo *MessageRepository) UnpinMessage(message *entity.Message) error {
-	return repo.DB.Model(message).Update("pinned", false).Error
+	return repo.DB.Model(message).Select("pinned", "pinned_at", "pinned_by_id").Updates(message).Error
+}
+func (repo *MessageRepository) CountChannelPins(channelID uint) (int64, error) {
+	var count int64
+	err := repo.DB.Model(&entity.Message{}).Where("channel_id = ? AND pinned = ?", channelID, true).Count(&count).Error
+	return count, err
+}
+func (repo *MessageRepository) GetChannelPins(channelID uint) ([]entity.Message, error) {
+	var messages []entity.Message
+	err := repo.DB.Where("channel_id = ? AND pinned = ?", channelID, true).
+		Preload("Medias").Preload("Reactions").Preload("User").Preload("Mentions").Preload("ReferencedMessage.User").Preload("PinnedBy").
+		Order("pinned_at DESC").Find(&messages).Error
+	return messages, err
 }
 
 // Reactions
@@ -68,3 +230,10 @@ func (repo *MessageRepository) GetMedia(mediaId string) (*entity.Media, error) {
 	err := repo.DB.Where("id = ?", mediaId).First(&media).Error
 	return &media, err
 }
+
+// reverseMessages turns a page fetched newest first into chronological order
+func reverseMessages(messages []entity.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
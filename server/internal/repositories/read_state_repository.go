@@ -0,0 +1,156 @@
+// repositories/read_state_repository.go
+package repositories
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"lesha.com/server/internal/entity"
+)
+
+type ReadStateRepository struct {
+	DB *gorm.DB
+}
+
+type channelCount struct {
+	ChannelID uint
+	Count     int
+}
+
+type userCount struct {
+	UserID uint
+	Count  int
+}
+
+func NewReadStateRepository(db *gorm.DB) *ReadStateRepository {
+	return &ReadStateRepository{DB: db}
+}
+
+// SaveReadState moves the last read message of a channel forward, never backward
+func (repo *ReadStateRepository) SaveReadState(readState *entity.ReadState) error {
+	return repo.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "channel_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_message_id": gorm.Expr("GREATEST(last_message_id, VALUES(last_message_id))"),
+			"updated_at":      gorm.Expr("VALUES(updated_at)"),
+		}),
+	}).Create(readState).Error
+}
+func (repo *ReadStateRepository) GetReadStates(userID uint, channelIDs []uint) (map[uint]uint, error) {
+	var readStates []entity.ReadState
+	err := repo.DB.Where("user_id = ? AND channel_id IN ?", userID, channelIDs).Find(&readStates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	lastRead := make(map[uint]uint, len(readStates))
+	for _, readState := range readStates {
+		lastRead[readState.ChannelID] = readState.LastMessageID
+	}
+	return lastRead, nil
+}
+
+// GetChannelReadStates returns the last read message of a channel for each of the users
+func (repo *ReadStateRepository) GetChannelReadStates(channelID uint, userIDs []uint) (map[uint]uint, error) {
+	var readStates []entity.ReadState
+	err := repo.DB.Where("channel_id = ? AND user_id IN ?", channelID, userIDs).Find(&readStates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	lastRead := make(map[uint]uint, len(readStates))
+	for _, readState := range readStates {
+		lastRead[readState.UserID] = readState.LastMessageID
+	}
+	return lastRead, nil
+}
+func (repo *ReadStateRepository) GetLatestMessageID(channelID uint) (uint, error) {
+	var messageID uint
+	err := repo.DB.Model(&entity.Message{}).Where("channel_id = ? AND thread_id IS NULL", channelID).
+		Select("COALESCE(MAX(id), 0)").Scan(&messageID).Error
+	return messageID, err
+}
+
+// CountUnread counts the messages of others posted after the last read message of each channel
+func (repo *ReadStateRepository) CountUnread(userID uint, channelIDs []uint) (map[uint]int, error) {
+	var counts []channelCount
+	err := repo.DB.Model(&entity.Message{}).
+		Select("messages.channel_id, COUNT(*) AS count").
+		Joins("LEFT JOIN read_states ON read_states.channel_id = messages.channel_id AND read_states.user_id = ?", userID).
+		Where("messages.channel_id IN ? AND messages.thread_id IS NULL AND messages.user_id <> ?", channelIDs, userID).
+		Where("messages.id > COALESCE(read_states.last_message_id, 0)").
+		Group("messages.channel_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return countsByChannel(counts), nil
+}
+
+// CountUnreadMentions counts the mentions of the user posted after the last read message of each channel
+func (repo *ReadStateRepository) CountUnreadMentions(userID uint, channelIDs []uint) (map[uint]int, error) {
+	var counts []channelCount
+	err := repo.DB.Model(&entity.UserMention{}).
+		Select("user_mentions.channel_id, COUNT(*) AS count").
+		Joins("JOIN messages ON messages.id = user_mentions.message_id AND messages.deleted_at IS NULL").
+		Joins("LEFT JOIN read_states ON read_states.channel_id = user_mentions.channel_id AND read_states.user_id = user_mentions.user_id").
+		Where("user_mentions.user_id = ? AND user_mentions.channel_id IN ?", userID, channelIDs).
+		Where("user_mentions.message_id > COALESCE(read_states.last_message_id, 0)").
+		Group("user_mentions.channel_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return countsByChannel(counts), nil
+}
+
+// CountChannelUnread counts for each of the users the messages of others posted in a channel
+// after their last read message
+func (repo *ReadStateRepository) CountChannelUnread(channelID uint, userIDs []uint) (map[uint]int, error) {
+	var counts []userCount
+	err := repo.DB.Table("users").
+		Select("users.id AS user_id, COUNT(*) AS count").
+		Joins("JOIN messages ON messages.channel_id = ? AND messages.thread_id IS NULL AND messages.deleted_at IS NULL AND messages.user_id <> users.id", channelID).
+		Joins("LEFT JOIN read_states ON read_states.channel_id = messages.channel_id AND read_states.user_id = users.id").
+		Where("users.id IN ?", userIDs).
+		Where("messages.id > COALESCE(read_states.last_message_id, 0)").
+		Group("users.id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return countsByUser(counts), nil
+}
+
+// CountChannelUnreadMentions counts for each of the users their mentions posted in a channel
+// after their last read message
+func (repo *ReadStateRepository) CountChannelUnreadMentions(channelID uint, userIDs []uint) (map[uint]int, error) {
+	var counts []userCount
+	err := repo.DB.Model(&entity.UserMention{}).
+		Select("user_mentions.user_id, COUNT(*) AS count").
+		Joins("JOIN messages ON messages.id = user_mentions.message_id AND messages.deleted_at IS NULL").
+		Joins("LEFT JOIN read_states ON read_states.channel_id = user_mentions.channel_id AND read_states.user_id = user_mentions.user_id").
+		Where("user_mentions.channel_id = ? AND user_mentions.user_id IN ?", channelID, userIDs).
+		Where("user_mentions.message_id > COALESCE(read_states.last_message_id, 0)").
+		Group("user_mentions.user_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return countsByUser(counts), nil
+}
+
+func countsByUser(counts []userCount) map[uint]int {
+	byUser := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		byUser[count.UserID] = count.Count
+	}
+	return byUser
+}
+
+func countsByChannel(counts []channelCount) map[uint]int {
+	byChannel := make(map[uint]int, len(counts))
+	for _, count := range counts {
+		byChannel[count.ChannelID] = count.Count
+	}
+	return byChannel
+}
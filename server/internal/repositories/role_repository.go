@@ -0,0 +1,76 @@
+// repositories/role_repository.go
+package repositories
+
+import (
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+type RoleRepository struct {
+	DB *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) *RoleRepository {
+	return &RoleRepository{DB: db}
+}
+
+func (repo *RoleRepository) CreateRole(role *entity.Role) error {
+	return repo.DB.Create(role).Error
+}
+func (repo *RoleRepository) GetRole(roleId uint) (*entity.Role, error) {
+	var role entity.Role
+	err := repo.DB.Where("id = ?", roleId).First(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+func (repo *RoleRepository) GetServerRoles(serverId string) ([]entity.Role, error) {
+	var roles []entity.Role
+	err := repo.DB.Where("server_id = ?", serverId).Order("position DESC").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+func (repo *RoleRepository) UpdateRole(role *entity.Role) error {
+	return repo.DB.Save(role).Error
+}
+func (repo *RoleRepository) DeleteRole(role *entity.Role) error {
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("role_id = ?", role.ID).Delete(&entity.MemberRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(role).Error
+	})
+}
+
+// Member roles
+func (repo *RoleRepository) AssignRole(serverID uint, userID uint, roleID uint) error {
+	memberRole := entity.MemberRole{ServerID: serverID, UserID: userID, RoleID: roleID}
+	return repo.DB.Where(memberRole).FirstOrCreate(&memberRole).Error
+}
+func (repo *RoleRepository) RemoveRole(serverID uint, userID uint, roleID uint) error {
+	return repo.DB.Unscoped().
+		Where("server_id = ? AND user_id = ? AND role_id = ?", serverID, userID, roleID).
+		Delete(&entity.MemberRole{}).Error
+}
+func (repo *RoleRepository) GetServerMemberRoles(serverID uint) ([]entity.MemberRole, error) {
+	var memberRoles []entity.MemberRole
+	err := repo.DB.Where("server_id = ?", serverID).Find(&memberRoles).Error
+	if err != nil {
+		return nil, err
+	}
+	return memberRoles, nil
+}
+func (repo *RoleRepository) GetMemberRoles(serverID uint, userID uint) ([]entity.Role, error) {
+	var roles []entity.Role
+	err := repo.DB.Joins("JOIN member_roles ON roles.id = member_roles.role_id").
+		Where("member_roles.server_id = ? AND member_roles.user_id = ?", serverID, userID).
+		Order("roles.position DESC").
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
@@ -2,6 +2,8 @@
 package repositories
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 )
@@ -46,6 +48,9 @@ func (repo *ServerRepository) GetUserServers(userID uint) ([]entity.Server, erro
 func (repo *ServerRepository) UpdateServer(server *entity.Server) error {
 	return repo.DB.Save(server).Error
 }
+func (repo *ServerRepository) UpdateServerOwner(server *entity.Server, userID uint) error {
+	return repo.DB.Model(server).Update("user_id", userID).Error
+}
 func (repo *ServerRepository) DeleteServer(server *entity.Server) error {
 	return repo.DB.Delete(server).Error
 }
@@ -71,6 +76,72 @@ func (repo *ServerRepository) AddUserToServer(serverID uint, userID uint) error
 	// Using the many-to-many relationship through user_servers table
 	return repo.DB.Exec("INSERT INTO user_servers (user_id, server_id) VALUES (?, ?)", userID, serverID).Error
 }
+func (repo *ServerRepository) AddTemporaryUserToServer(serverID uint, userID uint) error {
+	return repo.DB.Exec("INSERT INTO user_servers (user_id, server_id, temporary) VALUES (?, ?, ?)", userID, serverID, true).Error
+}
+func (repo *ServerRepository) RemoveUserFromServer(serverID uint, userID uint) error {
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM user_channels WHERE user_id = ? AND channel_id IN (SELECT id FROM channels WHERE server_id = ?)", userID, serverID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("server_id = ? AND user_id = ?", serverID, userID).Delete(&entity.MemberRole{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM user_servers WHERE user_id = ? AND server_id = ?", userID, serverID).Error
+	})
+}
+func (repo *ServerRepository) GetTemporaryServerIDs(userID uint) ([]uint, error) {
+	var serverIDs []uint
+	err := repo.DB.Table("user_servers").
+		Where("user_id = ? AND temporary = ?", userID, true).
+		Pluck("server_id", &serverIDs).Error
+	return serverIDs, err
+}
+func (repo *ServerRepository) GetMembership(serverID uint, userID uint) (*entity.UserServer, error) {
+	var membership entity.UserServer
+	err := repo.DB.Where("server_id = ? AND user_id = ?", serverID, userID).First(&membership).Error
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+func (repo *ServerRepository) SetMemberTimeout(serverID uint, userID uint, until *time.Time) error {
+	return repo.DB.Model(&entity.UserServer{}).
+		Where("server_id = ? AND user_id = ?", serverID, userID).
+		Update("timeout_until", until).Error
+}
+func (repo *ServerRepository) GetServerMemberIDs(serverID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Table("user_servers").Where("server_id = ?", serverID).Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+func (repo *ServerRepository) GetMemberships(serverID uint) ([]entity.UserServer, error) {
+	var memberships []entity.UserServer
+	err := repo.DB.Where("server_id = ?", serverID).Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+func (repo *ServerRepository) SetMemberNickname(serverID uint, userID uint, nickname string) error {
+	return repo.DB.Model(&entity.UserServer{}).
+		Where("server_id = ? AND user_id = ?", serverID, userID).
+		Update("nickname", nickname).Error
+}
+func (repo *ServerRepository) GetMemberNicknames(serverID uint, userIDs []uint) ([]entity.UserServer, error) {
+	var memberships []entity.UserServer
+	err := repo.DB.Where("server_id = ? AND user_id IN ? AND nickname <> ''", serverID, userIDs).Find(&memberships).Error
+	return memberships, err
+}
+func (repo *ServerRepository) IsServerMember(serverID uint, userID uint) (bool, error) {
+	var count int64
+	err := repo.DB.Table("user_servers").
+		Where("server_id = ? AND user_id = ?", serverID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
 
 func (repo *ServerRepository) CreateChannel(channel *entity.Channel) error {
 	return repo.DB.Create(channel).Error
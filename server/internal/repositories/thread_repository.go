@@ -0,0 +1,85 @@
+// repositories/thread_repository.go
+package repositories
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+type ThreadRepository struct {
+	DB *gorm.DB
+}
+
+func NewThreadRepository(db *gorm.DB) *ThreadRepository {
+	return &ThreadRepository{DB: db}
+}
+
+func (repo *ThreadRepository) CreateThread(thread *entity.Thread) error {
+	return repo.DB.Create(thread).Error
+}
+func (repo *ThreadRepository) GetThread(threadId uint) (*entity.Thread, error) {
+	var thread entity.Thread
+	err := repo.DB.Where("id = ?", threadId).Preload("User").First(&thread).Error
+	if err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+func (repo *ThreadRepository) GetThreadByStarterMessage(messageId uint) (*entity.Thread, error) {
+	var thread entity.Thread
+	err := repo.DB.Where("starter_message_id = ?", messageId).First(&thread).Error
+	if err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+func (repo *ThreadRepository) GetChannelThreads(channelId string) ([]entity.Thread, error) {
+	var threads []entity.Thread
+	err := repo.DB.Where("channel_id = ?", channelId).Preload("User").
+		Order("archived ASC").Order("last_message_at DESC").
+		Find(&threads).Error
+	if err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+func (repo *ThreadRepository) UpdateThread(thread *entity.Thread) error {
+	return repo.DB.Model(thread).Select("name", "archived", "auto_archive_duration").Updates(thread).Error
+}
+
+// RecordMessage bumps the activity of a thread after a message was posted in it
+func (repo *ThreadRepository) RecordMessage(thread *entity.Thread, postedAt time.Time) error {
+	return repo.DB.Model(thread).Updates(map[string]interface{}{
+		"last_message_at": postedAt,
+		"message_count":   gorm.Expr("message_count + 1"),
+		"archived":        false,
+	}).Error
+}
+
+// ArchiveInactiveThreads archives the threads of a channel without messages for their auto archive duration
+func (repo *ThreadRepository) ArchiveInactiveThreads(channelID uint, now time.Time) error {
+	return repo.DB.Model(&entity.Thread{}).
+		Where("channel_id = ? AND archived = ?", channelID, false).
+		Where("DATE_ADD(last_message_at, INTERVAL auto_archive_duration MINUTE) < ?", now).
+		Update("archived", true).Error
+}
+
+// Members
+func (repo *ThreadRepository) AddThreadMember(threadID uint, userID uint) error {
+	return repo.DB.Exec("INSERT IGNORE INTO thread_members (thread_id, user_id) VALUES (?, ?)", threadID, userID).Error
+}
+func (repo *ThreadRepository) RemoveThreadMember(threadID uint, userID uint) error {
+	return repo.DB.Exec("DELETE FROM thread_members WHERE thread_id = ? AND user_id = ?", threadID, userID).Error
+}
+func (repo *ThreadRepository) GetThreadMembers(threadID uint) ([]entity.User, error) {
+	var members []entity.User
+	err := repo.DB.Joins("JOIN thread_members ON users.id = thread_members.user_id").
+		Where("thread_members.thread_id = ?", threadID).
+		Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
@@ -2,6 +2,8 @@
 package repositories
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 )
@@ -59,6 +61,46 @@ func (repo *UserRepository) GetUserByEmail(email string) (*entity.User, error) {
 	return &user, nil
 }
 
+func (repo *UserRepository) UpdateUserStatus(userID uint, status string) error {
+	return repo.DB.Model(&entity.User{}).Where("id = ?", userID).Update("status", status).Error
+}
+
+func (repo *UserRepository) UpdateLastSeen(userID uint, lastSeenAt time.Time) error {
+	return repo.DB.Model(&entity.User{}).Where("id = ?", userID).Update("last_seen_at", lastSeenAt).Error
+}
+
+func (repo *UserRepository) UpdateProfile(user *entity.User) error {
+	return repo.DB.Model(user).
+		Select("avatar", "banner", "bio", "pronouns", "custom_status_text", "custom_status_emoji", "custom_status_expires_at").
+		Updates(user).Error
+}
+
+// GetSharedServerUserIDs returns the IDs of the members of the servers the user belongs to
+func (repo *UserRepository) GetSharedServerUserIDs(userID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Table("user_servers AS members").
+		Joins("JOIN user_servers AS own ON own.server_id = members.server_id").
+		Where("own.user_id = ? AND members.user_id <> ?", userID, userID).
+		Distinct().
+		Pluck("members.user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// GetRelatedUserIDs returns the IDs of the friends of the user and of the members of the servers they share
+func (repo *UserRepository) GetRelatedUserIDs(userID uint) ([]uint, error) {
+	var userIDs []uint
+	err := repo.DB.Raw(`
+		SELECT members.user_id FROM user_servers AS own
+		JOIN user_servers AS members ON members.server_id = own.server_id
+		WHERE own.user_id = ? AND members.user_id <> ?
+		UNION
+		SELECT CASE WHEN user_id = ? THEN friend_id ELSE user_id END FROM friendships
+		WHERE (user_id = ? OR friend_id = ?) AND status = ? AND deleted_at IS NULL`,
+		userID, userID, userID, userID, userID, entity.FriendshipAccepted).
+		Scan(&userIDs).Error
+	return userIDs, err
+}
+
 // BlacklistedTokenRepository methods
 
 func (repo *BlacklistedTokenRepository) GetBlacklistedToken(token string) (*entity.BlacklistedToken, error) {
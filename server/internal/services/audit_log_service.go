@@ -0,0 +1,51 @@
+package services
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+const (
+	DefaultAuditLogLimit = 50
+	MaxAuditLogLimit     = 100
+)
+
+type AuditLogService struct {
+	DB *gorm.DB
+}
+
+func NewAuditLogService(db *gorm.DB) *AuditLogService {
+	return &AuditLogService{DB: db}
+}
+
+// Record saves an audit log entry. Failures are logged and never block the audited action.
+func (service *AuditLogService) Record(entry *entity.AuditLogEntry) {
+	auditLogRepository := repositories.NewAuditLogRepository(service.DB)
+	if err := auditLogRepository.CreateEntry(entry); err != nil {
+		log.Printf("Failed to record audit log %s on server %d: %v", entry.Action, entry.ServerID, err)
+	}
+}
+
+func (service *AuditLogService) GetServerEntries(serverID uint, filter repositories.AuditLogFilter) ([]entity.AuditLogEntryResponse, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultAuditLogLimit
+	}
+	if filter.Limit > MaxAuditLogLimit {
+		filter.Limit = MaxAuditLogLimit
+	}
+
+	auditLogRepository := repositories.NewAuditLogRepository(service.DB)
+	entries, err := auditLogRepository.GetServerEntries(serverID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]entity.AuditLogEntryResponse, len(entries))
+	for i, entry := range entries {
+		responses[i] = entry.ToResponse()
+	}
+	return responses, nil
+}
@@ -1,11 +1,23 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/repositories"
 )
 
+// MaxGroupDMRecipients is the largest number of users in a group direct message channel, its creator included
+const MaxGroupDMRecipients = 10
+
+var (
+	ErrInvalidRecipients = errors.New("direct messages need at least one other existing user")
+	ErrTooManyRecipients = fmt.Errorf("group direct messages are limited to %d users", MaxGroupDMRecipients)
+)
+
 type ChannelService struct {
 	DB *gorm.DB
 }
@@ -48,3 +60,129 @@ func (service *ChannelService) GetServerChannels(serverId string) ([]entity.Chan
 	channelRepository := repositories.NewChannelRepository(service.DB)
 	return channelRepository.GetServerChannels(serverId)
 }
+
+func (service *ChannelService) GetChannelOverwrites(channelID uint) ([]entity.ChannelOverwrite, error) {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	return channelRepository.GetChannelOverwrites(channelID)
+}
+
+func (service *ChannelService) SaveChannelOverwrite(overwrite *entity.ChannelOverwrite) error {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	return channelRepository.SaveChannelOverwrite(overwrite)
+}
+
+func (service *ChannelService) DeleteChannelOverwrite(channelID uint, targetType string, targetID uint) error {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	return channelRepository.DeleteChannelOverwrite(channelID, targetType, targetID)
+}
+
+// OpenDirectChannel returns the direct message channel between the user and one
+// recipient, creating it on first use, or creates a group direct message channel
+// with several recipients. The boolean reports whether a channel was created.
+func (service *ChannelService) OpenDirectChannel(userID uint, recipientIDs []uint, name string) (*entity.Channel, bool, error) {
+	participants := map[uint]bool{userID: true}
+	for _, recipientID := range recipientIDs {
+		participants[recipientID] = true
+	}
+	if len(participants) < 2 {
+		return nil, false, ErrInvalidRecipients
+	}
+	if len(participants) > MaxGroupDMRecipients {
+		return nil, false, ErrTooManyRecipients
+	}
+
+	userRepository := repositories.NewUserRepository(service.DB)
+	recipients := make([]entity.User, 0, len(participants))
+	for participantID := range participants {
+		user, err := userRepository.GetUserById(fmt.Sprintf("%d", participantID))
+		if err != nil {
+			return nil, false, ErrInvalidRecipients
+		}
+		recipients = append(recipients, *user)
+	}
+
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	channel := entity.Channel{
+		Type:       entity.ChannelTypeGroupDM,
+		Name:       name,
+		Recipients: recipients,
+	}
+	if len(participants) == 2 {
+		// Both users always share the same channel, whoever opens it
+		ids := make([]uint, 0, 2)
+		for participantID := range participants {
+			ids = append(ids, participantID)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		key := fmt.Sprintf("%d:%d", ids[0], ids[1])
+
+		friendshipService := NewFriendshipService(service.DB)
+		blocked, err := friendshipService.IsEitherBlocked(ids[0], ids[1])
+		if err != nil {
+			return nil, false, err
+		}
+		if blocked {
+			return nil, false, ErrBlocked
+		}
+
+		existing, err := channelRepository.GetChannelByDMKey(key)
+		if err == nil {
+			return existing, false, nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			return nil, false, err
+		}
+		channel.Type = entity.ChannelTypeDM
+		channel.Name = ""
+		channel.DMKey = &key
+	}
+
+	if err := channelRepository.CreateDirectChannel(&channel); err != nil {
+		return nil, false, err
+	}
+	return &channel, true, nil
+}
+
+// GetUserDirectChannels returns the direct message channels of the user with their read state,
+// most recently active first. Direct messages with blocked users are hidden.
+func (service *ChannelService) GetUserDirectChannels(userID uint) ([]entity.DirectMessageChannelResponse, error) {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	allChannels, err := channelRepository.GetUserDirectChannels(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	friendshipService := NewFriendshipService(service.DB)
+	blocked, err := friendshipService.GetBlockedIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]entity.Channel, 0, len(allChannels))
+	for _, channel := range allChannels {
+		hidden := false
+		for _, recipient := range channel.Recipients {
+			hidden = hidden || (channel.Type == entity.ChannelTypeDM && blocked[recipient.ID])
+		}
+		if !hidden {
+			channels = append(channels, channel)
+		}
+	}
+
+	readStateService := NewReadStateService(service.DB)
+	channelsWithReadState, err := readStateService.GetChannelsWithReadState(userID, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]entity.DirectMessageChannelResponse, len(channelsWithReadState))
+	for i, channel := range channelsWithReadState {
+		responses[i] = channel.ToDirectMessageResponse()
+	}
+	return responses, nil
+}
+
+// GetChannelRecipientIDs returns the users of a direct message channel
+func (service *ChannelService) GetChannelRecipientIDs(channelID uint) ([]uint, error) {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	return channelRepository.GetChannelRecipientIDs(channelID)
+}
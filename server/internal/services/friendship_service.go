@@ -0,0 +1,299 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+var (
+	ErrInvalidFriendRequest = errors.New("you cannot send a friend request to yourself")
+	ErrAlreadyFriends       = errors.New("you are already friends")
+	ErrFriendRequestExists  = errors.New("a friend request is already pending")
+	ErrFriendRequestBlocked = errors.New("you cannot send a friend request to this user")
+	ErrNoFriendRequest      = errors.New("no pending friend request")
+	ErrNotFriends           = errors.New("you are not friends")
+	ErrInvalidBlock         = errors.New("you cannot block yourself")
+	ErrNotBlocked           = errors.New("this user is not blocked")
+	ErrBlocked              = errors.New("you cannot interact with this user")
+)
+
+type FriendshipService struct {
+	DB *gorm.DB
+}
+
+func NewFriendshipService(db *gorm.DB) *FriendshipService {
+	return &FriendshipService{DB: db}
+}
+
+// GetFriendship returns the relationship between two users, whoever started it
+func (service *FriendshipService) GetFriendship(userID uint, otherID uint) (*entity.Friendship, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	return friendshipRepository.GetFriendshipByPairKey(friendshipPairKey(userID, otherID))
+}
+
+// SendFriendRequest sends a friend request, or accepts the pending request of the other user
+func (service *FriendshipService) SendFriendRequest(userID uint, targetID uint) (*entity.Friendship, error) {
+	if userID == targetID {
+		return nil, ErrInvalidFriendRequest
+	}
+
+	userRepository := repositories.NewUserRepository(service.DB)
+	if _, err := userRepository.GetUserById(fmt.Sprintf("%d", targetID)); err != nil {
+		return nil, err
+	}
+
+	blocked, err := service.IsEitherBlocked(userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, ErrFriendRequestBlocked
+	}
+
+	friendship, err := service.GetFriendship(userID, targetID)
+	if err == nil {
+		switch {
+		case friendship.Status == entity.FriendshipAccepted:
+			return nil, ErrAlreadyFriends
+		case friendship.UserID == userID:
+			return nil, ErrFriendRequestExists
+		}
+		return service.AcceptFriendRequest(userID, targetID)
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	pairKey := friendshipPairKey(userID, targetID)
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	err = friendshipRepository.CreateFriendship(&entity.Friendship{
+		UserID:   userID,
+		FriendID: targetID,
+		Status:   entity.FriendshipPending,
+		PairKey:  &pairKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return friendshipRepository.GetFriendshipByPairKey(pairKey)
+}
+
+// AcceptFriendRequest accepts the pending request the requester sent to the user
+func (service *FriendshipService) AcceptFriendRequest(userID uint, requesterID uint) (*entity.Friendship, error) {
+	friendship, err := service.getIncomingRequest(userID, requesterID)
+	if err != nil {
+		return nil, err
+	}
+
+	friendship.Status = entity.FriendshipAccepted
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	if err := friendshipRepository.UpdateFriendshipStatus(friendship); err != nil {
+		return nil, err
+	}
+	return friendship, nil
+}
+
+// DeclineFriendRequest deletes the pending request the requester sent to the user
+func (service *FriendshipService) DeclineFriendRequest(userID uint, requesterID uint) (*entity.Friendship, error) {
+	friendship, err := service.getIncomingRequest(userID, requesterID)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.deleteFriendship(friendship)
+}
+
+// CancelFriendRequest deletes the pending request the user sent to the target
+func (service *FriendshipService) CancelFriendRequest(userID uint, targetID uint) (*entity.Friendship, error) {
+	friendship, err := service.GetFriendship(userID, targetID)
+	if err != nil || friendship.Status != entity.FriendshipPending || friendship.UserID != userID {
+		return nil, ErrNoFriendRequest
+	}
+
+	return service.deleteFriendship(friendship)
+}
+
+// RemoveFriend ends the friendship between two users
+func (service *FriendshipService) RemoveFriend(userID uint, friendID uint) (*entity.Friendship, error) {
+	friendship, err := service.GetFriendship(userID, friendID)
+	if err != nil || friendship.Status != entity.FriendshipAccepted {
+		return nil, ErrNotFriends
+	}
+
+	return service.deleteFriendship(friendship)
+}
+
+// GetFriends returns the users the user is friends with
+func (service *FriendshipService) GetFriends(userID uint) ([]entity.UserResponse, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	friendships, err := friendshipRepository.GetUserFriendships(userID, entity.FriendshipAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	friends := make([]entity.UserResponse, len(friendships))
+	for i, friendship := range friendships {
+		friends[i] = friendship.ToResponse(userID).User
+	}
+	return friends, nil
+}
+
+// GetPendingRequests returns the pending requests sent to the user and sent by the user
+func (service *FriendshipService) GetPendingRequests(userID uint) ([]entity.FriendshipResponse, []entity.FriendshipResponse, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	friendships, err := friendshipRepository.GetUserFriendships(userID, entity.FriendshipPending)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	incoming := make([]entity.FriendshipResponse, 0)
+	outgoing := make([]entity.FriendshipResponse, 0)
+	for _, friendship := range friendships {
+		if friendship.FriendID == userID {
+			incoming = append(incoming, friendship.ToResponse(userID))
+		} else {
+			outgoing = append(outgoing, friendship.ToResponse(userID))
+		}
+	}
+	return incoming, outgoing, nil
+}
+
+func (service *FriendshipService) getIncomingRequest(userID uint, requesterID uint) (*entity.Friendship, error) {
+	friendship, err := service.GetFriendship(userID, requesterID)
+	if err != nil || friendship.Status != entity.FriendshipPending || friendship.FriendID != userID {
+		return nil, ErrNoFriendRequest
+	}
+	return friendship, nil
+}
+
+// BlockUser blocks the target, ending any friendship or pending request between them.
+// It returns the relationship that was ended, if any, and the block.
+func (service *FriendshipService) BlockUser(userID uint, targetID uint) (*entity.Friendship, *entity.Friendship, error) {
+	if userID == targetID {
+		return nil, nil, ErrInvalidBlock
+	}
+
+	userRepository := repositories.NewUserRepository(service.DB)
+	if _, err := userRepository.GetUserById(fmt.Sprintf("%d", targetID)); err != nil {
+		return nil, nil, err
+	}
+
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	blockKey := blockPairKey(userID, targetID)
+	if block, err := friendshipRepository.GetFriendshipByPairKey(blockKey); err == nil {
+		return nil, block, nil
+	}
+
+	var ended *entity.Friendship
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		friendshipRepository := repositories.NewFriendshipRepository(tx)
+		friendship, err := friendshipRepository.GetFriendshipByPairKey(friendshipPairKey(userID, targetID))
+		if err == nil {
+			if err := friendshipRepository.DeleteFriendship(friendship); err != nil {
+				return err
+			}
+			friendship.Status = entity.FriendshipNone
+			ended = friendship
+		} else if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		return friendshipRepository.CreateFriendship(&entity.Friendship{
+			UserID:   userID,
+			FriendID: targetID,
+			Status:   entity.FriendshipBlocked,
+			PairKey:  &blockKey,
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := friendshipRepository.GetFriendshipByPairKey(blockKey)
+	return ended, block, err
+}
+
+// UnblockUser removes the block of the user on the target
+func (service *FriendshipService) UnblockUser(userID uint, targetID uint) (*entity.Friendship, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	block, err := friendshipRepository.GetFriendshipByPairKey(blockPairKey(userID, targetID))
+	if err != nil {
+		return nil, ErrNotBlocked
+	}
+	return service.deleteFriendship(block)
+}
+
+// GetBlockedUsers returns the users blocked by the user
+func (service *FriendshipService) GetBlockedUsers(userID uint) ([]entity.UserResponse, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	blocks, err := friendshipRepository.GetBlockedUsers(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]entity.UserResponse, len(blocks))
+	for i, block := range blocks {
+		users[i] = block.ToResponse(userID).User
+	}
+	return users, nil
+}
+
+// IsBlocked reports whether the blocker blocked the other user
+func (service *FriendshipService) IsBlocked(blockerID uint, blockedID uint) (bool, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	return friendshipRepository.IsBlocked(blockerID, blockedID)
+}
+
+// IsEitherBlocked reports whether one of the two users blocked the other
+func (service *FriendshipService) IsEitherBlocked(userID uint, otherID uint) (bool, error) {
+	blocked, err := service.IsBlocked(userID, otherID)
+	if err != nil || blocked {
+		return blocked, err
+	}
+	return service.IsBlocked(otherID, userID)
+}
+
+// GetBlockedIDs returns the set of users blocked by the user
+func (service *FriendshipService) GetBlockedIDs(userID uint) (map[uint]bool, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	return toSet(friendshipRepository.GetBlockedIDs(userID))
+}
+
+// GetBlockerIDs returns the set of users who blocked the user
+func (service *FriendshipService) GetBlockerIDs(userID uint) (map[uint]bool, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	return toSet(friendshipRepository.GetBlockerIDs(userID))
+}
+
+func (service *FriendshipService) deleteFriendship(friendship *entity.Friendship) (*entity.Friendship, error) {
+	friendshipRepository := repositories.NewFriendshipRepository(service.DB)
+	if err := friendshipRepository.DeleteFriendship(friendship); err != nil {
+		return nil, err
+	}
+	friendship.Status = entity.FriendshipNone
+	return friendship, nil
+}
+
+// friendshipPairKey identifies a pair of users regardless of the order
+func friendshipPairKey(userID uint, otherID uint) string {
+	if userID > otherID {
+		userID, otherID = otherID, userID
+	}
+	return fmt.Sprintf("%d:%d", userID, otherID)
+}
+
+// blockPairKey identifies a block of one user by another, blocks are directional
+func blockPairKey(blockerID uint, blockedID uint) string {
+	return fmt.Sprintf("%d>%d", blockerID, blockedID)
+}
+
+func toSet(userIDs []uint, err error) (map[uint]bool, error) {
+	if err != nil {
+		return nil, err
+	}
+	return idSet(userIDs), nil
+}
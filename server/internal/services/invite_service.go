@@ -0,0 +1,134 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+const inviteCodeAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const inviteCodeLength = 8
+
+var (
+	ErrInviteExpired = errors.New("invite is expired or has reached its maximum uses")
+	ErrAlreadyMember = errors.New("user is already a member of this server")
+)
+
+type InviteService struct {
+	DB *gorm.DB
+}
+
+func NewInviteService(db *gorm.DB) *InviteService {
+	return &InviteService{DB: db}
+}
+
+// CreateInvite generates a random code for the invite and saves it
+func (service *InviteService) CreateInvite(invite *entity.Invite) error {
+	code, err := generateInviteCode()
+	if err != nil {
+		return err
+	}
+	invite.Code = code
+
+	inviteRepository := repositories.NewInviteRepository(service.DB)
+	return inviteRepository.CreateInvite(invite)
+}
+
+func (service *InviteService) GetInviteByCode(code string) (*entity.Invite, error) {
+	inviteRepository := repositories.NewInviteRepository(service.DB)
+	return inviteRepository.GetInviteByCode(code)
+}
+
+func (service *InviteService) GetServerInvites(serverId string) ([]entity.Invite, error) {
+	inviteRepository := repositories.NewInviteRepository(service.DB)
+	return inviteRepository.GetServerInvites(serverId)
+}
+
+func (service *InviteService) DeleteInvite(invite *entity.Invite) error {
+	inviteRepository := repositories.NewInviteRepository(service.DB)
+	return inviteRepository.DeleteInvite(invite)
+}
+
+// AcceptInvite adds the user to the server of the invite and to all of its channels
+func (service *InviteService) AcceptInvite(code string, userID uint) (*entity.Server, error) {
+	inviteRepository := repositories.NewInviteRepository(service.DB)
+	invite, err := inviteRepository.GetInviteByCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+		return nil, ErrInviteExpired
+	}
+
+	err = service.DB.Transaction(func(tx *gorm.DB) error {
+		serverRepository := repositories.NewServerRepository(tx)
+		channelRepository := repositories.NewChannelRepository(tx)
+
+		isMember, err := serverRepository.IsServerMember(invite.ServerID, userID)
+		if err != nil {
+			return err
+		}
+		if isMember {
+			return ErrAlreadyMember
+		}
+
+		if _, err := repositories.NewBanRepository(tx).GetActiveBan(invite.ServerID, userID); err == nil {
+			return ErrBanned
+		} else if err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		used, err := repositories.NewInviteRepository(tx).UseInvite(invite)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInviteExpired
+		}
+
+		if invite.Temporary {
+			err = serverRepository.AddTemporaryUserToServer(invite.ServerID, userID)
+		} else {
+			err = serverRepository.AddUserToServer(invite.ServerID, userID)
+		}
+		if err != nil {
+			return err
+		}
+
+		channels, err := serverRepository.GetServerChannels(fmt.Sprintf("%d", invite.ServerID))
+		if err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			if err := channelRepository.AddUserToChannel(channel.ID, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &invite.Server, nil
+}
+
+func generateInviteCode() (string, error) {
+	code := make([]byte, inviteCodeLength)
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+const (
+	DefaultMentionLimit = 25
+	MaxMentionLimit     = 100
+)
+
+// mentionPattern matches @name and #name tokens that are not part of a word, such as an email address
+var mentionPattern = regexp.MustCompile(`(?:^|\W)([@#])([\w.-]+)`)
+
+type MentionService struct {
+	DB *gorm.DB
+}
+
+func NewMentionService(db *gorm.DB) *MentionService {
+	return &MentionService{DB: db}
+}
+
+// ResolveMentions fills the mentions of a message from its content, and the users
+// to notify: mentioned members, members of mentioned roles, or everyone for @everyone
+// when the author has the MENTION_EVERYONE permission in the channel.
+// Only members who can read the channel are notified, never the author nor the
+// users who blocked the author. Direct message channels only support mentioning
+// their recipients.
+func (service *MentionService) ResolveMentions(message *entity.Message) error {
+	message.Mentions = nil
+	message.MentionedUsers = nil
+
+	tokens := mentionPattern.FindAllStringSubmatch(message.Content, -1)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	channel, err := channelRepository.GetChannel(message.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	mentionRepository := repositories.NewMentionRepository(service.DB)
+	permissionService := NewPermissionService(service.DB)
+	seen := make(map[string]bool)
+	var recipients []uint
+	for _, token := range tokens {
+		prefix, name := token[1], strings.TrimRight(token[2], ".-")
+		if name == "" || seen[prefix+name] {
+			continue
+		}
+		seen[prefix+name] = true
+
+		var mention entity.Mention
+		switch {
+		case channel.IsDirect():
+			if prefix == "#" {
+				continue
+			}
+			user, err := mentionRepository.FindChannelRecipientByName(channel.ID, name)
+			if err != nil {
+				continue
+			}
+			mention = entity.Mention{Type: entity.MentionUser, TargetID: user.ID}
+			recipients = append(recipients, user.ID)
+		case prefix == "#":
+			mentioned, err := mentionRepository.FindServerChannelByName(*channel.ServerID, name)
+			if err != nil {
+				continue
+			}
+			mention = entity.Mention{Type: entity.MentionChannel, TargetID: mentioned.ID}
+		case name == "everyone":
+			allowed, err := permissionService.HasChannelPermission(channel.ID, message.UserID, entity.PermissionMentionEveryone)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				continue
+			}
+			userIDs, err := mentionRepository.GetServerMemberIDs(*channel.ServerID)
+			if err != nil {
+				return err
+			}
+			mention = entity.Mention{Type: entity.MentionEveryone}
+			recipients = append(recipients, userIDs...)
+		default:
+			if user, err := mentionRepository.FindServerMemberByName(*channel.ServerID, name); err == nil {
+				mention = entity.Mention{Type: entity.MentionUser, TargetID: user.ID}
+				recipients = append(recipients, user.ID)
+				break
+			}
+			role, err := mentionRepository.FindServerRoleByName(*channel.ServerID, name)
+			if err != nil {
+				continue
+			}
+			userIDs, err := mentionRepository.GetRoleMemberIDs(role.ID)
+			if err != nil {
+				return err
+			}
+			mention = entity.Mention{Type: entity.MentionRole, TargetID: role.ID}
+			recipients = append(recipients, userIDs...)
+		}
+		message.Mentions = append(message.Mentions, mention)
+	}
+
+	friendshipService := NewFriendshipService(service.DB)
+	blockers, err := friendshipService.GetBlockerIDs(message.UserID)
+	if err != nil {
+		return err
+	}
+
+	notified := map[uint]bool{message.UserID: true}
+	for _, userID := range recipients {
+		if notified[userID] || blockers[userID] {
+			continue
+		}
+		notified[userID] = true
+
+		allowed, err := permissionService.CanAccessChannel(message.ChannelID, userID)
+		if err != nil || !allowed {
+			continue
+		}
+		message.MentionedUsers = append(message.MentionedUsers, entity.UserMention{
+			UserID:    userID,
+			ChannelID: message.ChannelID,
+		})
+	}
+	return nil
+}
+
+// GetUserMentions returns the latest messages mentioning the user in channels they can still read
+func (service *MentionService) GetUserMentions(userID uint, before uint, limit int) ([]entity.MessageResponse, error) {
+	if limit <= 0 {
+		limit = DefaultMentionLimit
+	}
+	if limit > MaxMentionLimit {
+		limit = MaxMentionLimit
+	}
+
+	// Mentions in channels the user cannot read are skipped, so pages are fetched until
+	// enough readable ones are found
+	mentionRepository := repositories.NewMentionRepository(service.DB)
+	permissionService := NewPermissionService(service.DB)
+	readable := make(map[uint]bool)
+	responses := make([]entity.MessageResponse, 0, limit)
+	for len(responses) < limit {
+		messages, err := mentionRepository.GetUserMentions(userID, before, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, message := range messages {
+			allowed, checked := readable[message.ChannelID]
+			if !checked {
+				allowed, _ = permissionService.CanAccessChannel(message.ChannelID, userID)
+				readable[message.ChannelID] = allowed
+			}
+			if allowed && len(responses) < limit {
+				responses = append(responses, message.ToResponse())
+			}
+		}
+
+		if len(messages) < limit {
+			break
+		}
+		before = messages[len(messages)-1].ID
+	}
+	return responses, nil
+}
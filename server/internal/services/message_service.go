@@ -1,11 +1,29 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/repositories"
 )
 
+const (
+	DefaultMessagePageLimit = 50
+	MaxMessagePageLimit     = 100
+)
+
+// MaxChannelPins is the number of messages that can be pinned in a channel
+const MaxChannelPins = 50
+
+var (
+	ErrInvalidReference = errors.New("referenced message is not in this channel")
+	ErrTooManyPins      = fmt.Errorf("a channel can have at most %d pinned messages", MaxChannelPins)
+)
+
 type MessageService struct {
 	DB *gorm.DB
 }
@@ -14,9 +32,89 @@ func NewMessageService(db *gorm.DB) *MessageService {
 	return &MessageService{DB: db}
 }
 
+// CreateMessage saves a message along with the mentions found in its content
 func (service *MessageService) CreateMessage(message *entity.Message) error {
+	mentionService := NewMentionService(service.DB)
+	if err := mentionService.ResolveMentions(message); err != nil {
+		return err
+	}
+
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	if err := messageRepository.CreateMessage(message); err != nil {
+		return err
+	}
+	service.indexMessage(message.ID)
+	return nil
+}
+
+// ValidateReference checks that the message replied to exists in the same channel
+func (service *MessageService) ValidateReference(message *entity.Message) error {
+	if message.ReferencedMessageID == nil {
+		return nil
+	}
+
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	referencedMessage, err := messageRepository.GetMessage(fmt.Sprintf("%d", *message.ReferencedMessageID))
+	if err != nil || referencedMessage.ChannelID != message.ChannelID {
+		return ErrInvalidReference
+	}
+	return nil
+}
+
+// EditMessage replaces the content of a message, keeping the previous content as a revision
+func (service *MessageService) EditMessage(message *entity.Message, content string) error {
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		messageRepository := repositories.NewMessageRepository(tx)
+
+		revision := entity.MessageRevision{
+			MessageID: message.ID,
+			Content:   message.Content,
+		}
+		if err := messageRepository.CreateRevision(&revision); err != nil {
+			return err
+		}
+
+		editedAt := time.Now()
+		message.Content = content
+		message.EditedAt = &editedAt
+		message.RevisionCount++
+		return messageRepository.UpdateMessageContent(message)
+	})
+	if err != nil {
+		return err
+	}
+	service.indexMessage(message.ID)
+	return nil
+}
+
+func (service *MessageService) GetRevisions(messageId string) ([]entity.MessageRevisionResponse, error) {
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	revisions, err := messageRepository.GetRevisions(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]entity.MessageRevisionResponse, len(revisions))
+	for i, revision := range revisions {
+		responses[i] = entity.MessageRevisionResponse{
+			ID:         revision.ID,
+			MessageID:  revision.MessageID,
+			Content:    revision.Content,
+			ReplacedAt: revision.CreatedAt,
+		}
+	}
+	return responses, nil
+}
+
+func (service *MessageService) DeleteMessage(message *entity.Message) error {
 	messageRepository := repositories.NewMessageRepository(service.DB)
-	return messageRepository.CreateMessage(message)
+	if err := messageRepository.DeleteMessage(message); err != nil {
+		return err
+	}
+	if err := searchIndex.Remove(message.ID); err != nil {
+		log.Printf("Failed to remove message %d from the search index: %v", message.ID, err)
+	}
+	return nil
 }
 
 func (service *MessageService) GetMessage(messageId string) (*entity.Message, error) {
@@ -24,9 +122,54 @@ func (service *MessageService) GetMessage(messageId string) (*entity.Message, er
 	return messageRepository.GetMessage(messageId)
 }
 
-func (service *MessageService) GetChannelMessages(channelId string) ([]entity.MessageResponse, error) {
+// GetChannelMessages returns a page of the channel history in chronological order,
+// collapsing the messages of users blocked by the viewer
+func (service *MessageService) GetChannelMessages(channelId string, viewerID uint, page repositories.MessagePage) ([]entity.MessageResponse, error) {
+	if page.Limit <= 0 {
+		page.Limit = DefaultMessagePageLimit
+	}
+	if page.Limit > MaxMessagePageLimit {
+		page.Limit = MaxMessagePageLimit
+	}
+
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	messages, err := messageRepository.GetChannelMessages(channelId, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.toResponses(messages, viewerID)
+}
+
+// GetThreadMessages returns a page of the thread history in chronological order,
+// collapsing the messages of users blocked by the viewer
+func (service *MessageService) GetThreadMessages(threadId string, viewerID uint, page repositories.MessagePage) ([]entity.MessageResponse, error) {
+	if page.Limit <= 0 {
+		page.Limit = DefaultMessagePageLimit
+	}
+	if page.Limit > MaxMessagePageLimit {
+		page.Limit = MaxMessagePageLimit
+	}
+
 	messageRepository := repositories.NewMessageRepository(service.DB)
-	messages, err := messageRepository.GetChannelMessages(channelId)
+	messages, err := messageRepository.GetThreadMessages(threadId, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.toResponses(messages, viewerID)
+}
+
+// toResponses converts messages for the viewer, hiding the content of the messages
+// and replies written by users they blocked, and showing the nicknames of the authors
+func (service *MessageService) toResponses(messages []entity.Message, viewerID uint) ([]entity.MessageResponse, error) {
+	friendshipService := NewFriendshipService(service.DB)
+	blocked, err := friendshipService.GetBlockedIDs(viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	nicknames, err := service.getNicknames(messages)
 	if err != nil {
 		return nil, err
 	}
@@ -34,19 +177,96 @@ func (service *MessageService) GetChannelMessages(channelId string) ([]entity.Me
 	responses := make([]entity.MessageResponse, len(messages))
 	for i, message := range messages {
 		responses[i] = message.ToResponse()
+		responses[i].User.Nickname = nicknames[message.UserID]
+		if blocked[message.UserID] {
+			responses[i].Blocked = true
+			responses[i].Content = ""
+			responses[i].Medias = []entity.MediaResponse{}
+		}
+		if reference := responses[i].ReferencedMessage; reference != nil && blocked[reference.User.ID] {
+			reference.Content = ""
+		}
 	}
-
 	return responses, nil
 }
 
-func (service *MessageService) PinMessage(message *entity.Message) error {
-	messageRepository := repositories.NewMessageRepository(service.DB)
-	return messageRepository.PinMessage(message)
+// getNicknames maps the authors of messages from the same server channel to their nickname in the server
+func (service *MessageService) getNicknames(messages []entity.Message) (map[uint]string, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	channel, err := channelRepository.GetChannel(messages[0].ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if channel.IsDirect() {
+		return nil, nil
+	}
+
+	userIDs := make([]uint, len(messages))
+	for i, message := range messages {
+		userIDs[i] = message.UserID
+	}
+	return NewServerService(service.DB).GetMemberNicknames(*channel.ServerID, userIDs)
+}
+
+// PinMessage pins a message on behalf of the user, up to MaxChannelPins per channel.
+// Pinning a pinned message does nothing.
+func (service *MessageService) PinMessage(message *entity.Message, userID uint) error {
+	if message.Pinned {
+		return nil
+	}
+
+	// The channel row is locked so that concurrent pins cannot go past MaxChannelPins
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := repositories.NewChannelRepository(tx).LockChannel(message.ChannelID); err != nil {
+			return err
+		}
+
+		messageRepository := repositories.NewMessageRepository(tx)
+		count, err := messageRepository.CountChannelPins(message.ChannelID)
+		if err != nil {
+			return err
+		}
+		if count >= MaxChannelPins {
+			return ErrTooManyPins
+		}
+
+		pinnedAt := time.Now()
+		message.Pinned = true
+		message.PinnedAt = &pinnedAt
+		message.PinnedByID = &userID
+		return messageRepository.PinMessage(message)
+	})
+	if err != nil {
+		return err
+	}
+	service.indexMessage(message.ID)
+	return nil
 }
 
 func (service *MessageService) UnpinMessage(message *entity.Message) error {
+	message.Pinned = false
+	message.PinnedAt = nil
+	message.PinnedByID = nil
+
 	messageRepository := repositories.NewMessageRepository(service.DB)
-	return messageRepository.UnpinMessage(message)
+	if err := messageRepository.UnpinMessage(message); err != nil {
+		return err
+	}
+	service.indexMessage(message.ID)
+	return nil
+}
+
+// GetChannelPins returns the pinned messages of a channel, last pinned first
+func (service *MessageService) GetChannelPins(channelID uint, viewerID uint) ([]entity.MessageResponse, error) {
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	messages, err := messageRepository.GetChannelPins(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return service.toResponses(messages, viewerID)
 }
 
 func (service *MessageService) AddReaction(reaction *entity.Reaction) error {
@@ -66,12 +286,33 @@ func (service *MessageService) GetReactions(messageId string) ([]entity.Reaction
 
 func (service *MessageService) AddMedia(media *entity.Media) error {
 	messageRepository := repositories.NewMessageRepository(service.DB)
-	return messageRepository.AddMedia(media)
+	if err := messageRepository.AddMedia(media); err != nil {
+		return err
+	}
+	service.indexMessage(media.MessageID)
+	return nil
 }
 
 func (service *MessageService) RemoveMedia(media *entity.Media) error {
 	messageRepository := repositories.NewMessageRepository(service.DB)
-	return messageRepository.RemoveMedia(media)
+	if err := messageRepository.RemoveMedia(media); err != nil {
+		return err
+	}
+	service.indexMessage(media.MessageID)
+	return nil
+}
+
+// indexMessage refreshes a message in the search index. Indexing is best effort, a
+// failure does not undo the change made to the message.
+func (service *MessageService) indexMessage(messageID uint) {
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	message, err := messageRepository.GetMessage(fmt.Sprintf("%d", messageID))
+	if err == nil {
+		err = searchIndex.Index(message)
+	}
+	if err != nil {
+		log.Printf("Failed to index message %d: %v", messageID, err)
+	}
 }
 
 func (service *MessageService) GetMedia(mediaId string) (*entity.Media, error) {
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+var ErrBanned = errors.New("user is banned from this server")
+
+type ModerationService struct {
+	DB *gorm.DB
+}
+
+func NewModerationService(db *gorm.DB) *ModerationService {
+	return &ModerationService{DB: db}
+}
+
+// KickMember removes the user from the server and all of its channels
+func (service *ModerationService) KickMember(serverID uint, userID uint) error {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	return serverRepository.RemoveUserFromServer(serverID, userID)
+}
+
+// BanMember records the ban and removes the user from the server if they are a member
+func (service *ModerationService) BanMember(ban *entity.Ban) error {
+	return service.DB.Transaction(func(tx *gorm.DB) error {
+		if err := repositories.NewBanRepository(tx).SaveBan(ban); err != nil {
+			return err
+		}
+		return repositories.NewServerRepository(tx).RemoveUserFromServer(ban.ServerID, ban.UserID)
+	})
+}
+
+func (service *ModerationService) UnbanMember(serverID uint, userID uint) error {
+	banRepository := repositories.NewBanRepository(service.DB)
+	return banRepository.DeleteBan(serverID, userID)
+}
+
+func (service *ModerationService) GetServerBans(serverId string) ([]entity.Ban, error) {
+	banRepository := repositories.NewBanRepository(service.DB)
+	return banRepository.GetServerBans(serverId)
+}
+
+// IsBanned reports whether the user has a ban in the server that has not expired
+func (service *ModerationService) IsBanned(serverID uint, userID uint) (bool, error) {
+	banRepository := repositories.NewBanRepository(service.DB)
+	_, err := banRepository.GetActiveBan(serverID, userID)
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// TimeoutMember prevents the user from sending messages until the given time, nil clears the timeout
+func (service *ModerationService) TimeoutMember(serverID uint, userID uint, until *time.Time) error {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	return serverRepository.SetMemberTimeout(serverID, userID, until)
+}
@@ -0,0 +1,424 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+type PermissionService struct {
+	DB *gorm.DB
+}
+
+func NewPermissionService(db *gorm.DB) *PermissionService {
+	return &PermissionService{DB: db}
+}
+
+// GetServerPermissions computes the permission bitset of a user in a server.
+// The owner gets every permission, members get the defaults plus the permissions
+// of their roles, and non members get none. Timed out members lose the
+// permission to send messages.
+func (service *PermissionService) GetServerPermissions(serverID uint, userID uint) (int64, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	server, err := serverRepository.GetServer(fmt.Sprintf("%d", serverID))
+	if err != nil {
+		return 0, err
+	}
+	if server.UserID == userID {
+		return entity.PermissionAll, nil
+	}
+
+	membership, err := serverRepository.GetMembership(serverID, userID)
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	roles, err := roleRepository.GetMemberRoles(serverID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	permissions := entity.DefaultPermissions
+	for _, role := range roles {
+		permissions |= role.Permissions
+	}
+	if isTimedOut(membership) {
+		permissions &^= entity.TimeoutRevokedPermissions
+	}
+	return permissions, nil
+}
+
+func isTimedOut(membership *entity.UserServer) bool {
+	return membership.TimeoutUntil != nil && membership.TimeoutUntil.After(time.Now())
+}
+
+// GetChannelPermissions computes the permission bitset of a user in a channel.
+// The server permissions are adjusted by the channel overwrites in order:
+// everyone, then the member's roles, then the member itself. Overwrites cannot
+// give back the permissions of a timed out member. Recipients of direct message
+// channels get DirectMessagePermissions.
+func (service *PermissionService) GetChannelPermissions(channelID uint, userID uint) (int64, error) {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	channel, err := channelRepository.GetChannel(channelID)
+	if err != nil {
+		return 0, err
+	}
+
+	if channel.IsDirect() {
+		return service.getDirectChannelPermissions(channel, userID)
+	}
+	serverID := *channel.ServerID
+
+	permissions, err := service.GetServerPermissions(serverID, userID)
+	if err != nil || permissions == 0 {
+		return permissions, err
+	}
+	owner, err := service.IsServerOwner(serverID, userID)
+	if err != nil || owner {
+		return permissions, err
+	}
+
+	overwrites, err := channelRepository.GetChannelOverwrites(channelID)
+	if err != nil {
+		return 0, err
+	}
+	if len(overwrites) == 0 {
+		return permissions, nil
+	}
+
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	roles, err := roleRepository.GetMemberRoles(serverID, userID)
+	if err != nil {
+		return 0, err
+	}
+	memberRoles := make(map[uint]bool, len(roles))
+	for _, role := range roles {
+		memberRoles[role.ID] = true
+	}
+
+	membership, err := repositories.NewServerRepository(service.DB).GetMembership(serverID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return applyOverwrites(permissions, overwrites, userID, memberRoles, isTimedOut(membership)), nil
+}
+
+// applyOverwrites adjusts the server permissions of a member by the overwrites of a channel
+func applyOverwrites(permissions int64, overwrites []entity.ChannelOverwrite, userID uint, memberRoles map[uint]bool, timedOut bool) int64 {
+	var roleAllow, roleDeny int64
+	var memberOverwrite *entity.ChannelOverwrite
+	for i, overwrite := range overwrites {
+		switch overwrite.TargetType {
+		case entity.OverwriteEveryone:
+			permissions = (permissions &^ overwrite.Deny) | overwrite.Allow
+		case entity.OverwriteRole:
+			if memberRoles[overwrite.TargetID] {
+				roleAllow |= overwrite.Allow
+				roleDeny |= overwrite.Deny
+			}
+		case entity.OverwriteMember:
+			if overwrite.TargetID == userID {
+				memberOverwrite = &overwrites[i]
+			}
+		}
+	}
+	permissions = (permissions &^ roleDeny) | roleAllow
+	if memberOverwrite != nil {
+		permissions = (permissions &^ memberOverwrite.Deny) | memberOverwrite.Allow
+	}
+
+	if timedOut {
+		permissions &^= entity.TimeoutRevokedPermissions
+	}
+	return permissions
+}
+
+// ServerPermissionSet holds the members, roles and channel overwrites of a server, so that
+// the permissions of all its members are computed without querying the database for each
+type ServerPermissionSet struct {
+	ownerID    uint
+	members    map[uint]*memberPermissions
+	overwrites map[uint][]entity.ChannelOverwrite
+}
+
+type memberPermissions struct {
+	permissions int64 // Server permissions, without those revoked by a timeout
+	roles       map[uint]bool
+	timedOut    bool
+}
+
+// GetServerPermissionSet loads what the permissions of the members of a server depend on
+func (service *PermissionService) GetServerPermissionSet(serverID uint) (*ServerPermissionSet, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	server, err := serverRepository.GetServer(fmt.Sprintf("%d", serverID))
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := serverRepository.GetMemberships(serverID)
+	if err != nil {
+		return nil, err
+	}
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	roles, err := roleRepository.GetServerRoles(fmt.Sprintf("%d", serverID))
+	if err != nil {
+		return nil, err
+	}
+	memberRoles, err := roleRepository.GetServerMemberRoles(serverID)
+	if err != nil {
+		return nil, err
+	}
+	overwrites, err := repositories.NewChannelRepository(service.DB).GetServerOverwrites(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &ServerPermissionSet{
+		ownerID:    server.UserID,
+		members:    make(map[uint]*memberPermissions, len(memberships)),
+		overwrites: make(map[uint][]entity.ChannelOverwrite),
+	}
+	for i, membership := range memberships {
+		set.members[membership.UserID] = &memberPermissions{
+			permissions: entity.DefaultPermissions,
+			roles:       make(map[uint]bool),
+			timedOut:    isTimedOut(&memberships[i]),
+		}
+	}
+	rolePermissions := make(map[uint]int64, len(roles))
+	for _, role := range roles {
+		rolePermissions[role.ID] = role.Permissions
+	}
+	for _, memberRole := range memberRoles {
+		if member, ok := set.members[memberRole.UserID]; ok {
+			member.roles[memberRole.RoleID] = true
+			member.permissions |= rolePermissions[memberRole.RoleID]
+		}
+	}
+	for _, member := range set.members {
+		if member.timedOut {
+			member.permissions &^= entity.TimeoutRevokedPermissions
+		}
+	}
+	for _, overwrite := range overwrites {
+		set.overwrites[overwrite.ChannelID] = append(set.overwrites[overwrite.ChannelID], overwrite)
+	}
+	return set, nil
+}
+
+// MemberIDs returns the members of the server
+func (set *ServerPermissionSet) MemberIDs() []uint {
+	memberIDs := make([]uint, 0, len(set.members))
+	for memberID := range set.members {
+		memberIDs = append(memberIDs, memberID)
+	}
+	return memberIDs
+}
+
+// ChannelPermissions computes the permission bitset of a user in a channel of the server,
+// like GetChannelPermissions
+func (set *ServerPermissionSet) ChannelPermissions(channelID uint, userID uint) int64 {
+	if userID == set.ownerID {
+		return entity.PermissionAll
+	}
+	member, ok := set.members[userID]
+	if !ok || member.permissions == 0 {
+		return 0
+	}
+	return applyOverwrites(member.permissions, set.overwrites[channelID], userID, member.roles, member.timedOut)
+}
+
+// CanAccessChannel checks that the user can view a channel of the server
+func (set *ServerPermissionSet) CanAccessChannel(channelID uint, userID uint) bool {
+	return set.ChannelPermissions(channelID, userID)&entity.PermissionViewChannel != 0
+}
+
+// getDirectChannelPermissions gives DirectMessagePermissions to the recipients of a
+// direct message channel. When one of the two users of a DM blocked the other, they
+// can only read the history.
+func (service *PermissionService) getDirectChannelPermissions(channel *entity.Channel, userID uint) (int64, error) {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	recipientIDs, err := channelRepository.GetChannelRecipientIDs(channel.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	recipient := false
+	for _, recipientID := range recipientIDs {
+		recipient = recipient || recipientID == userID
+	}
+	if !recipient {
+		return 0, nil
+	}
+
+	if channel.Type == entity.ChannelTypeDM {
+		friendshipService := NewFriendshipService(service.DB)
+		for _, recipientID := range recipientIDs {
+			if recipientID == userID {
+				continue
+			}
+			blocked, err := friendshipService.IsEitherBlocked(userID, recipientID)
+			if err != nil {
+				return 0, err
+			}
+			if blocked {
+				return entity.PermissionViewChannel, nil
+			}
+		}
+	}
+	return entity.DirectMessagePermissions, nil
+}
+
+// IsServerMember reports whether the user belongs to the server
+func (service *PermissionService) IsServerMember(serverID uint, userID uint) (bool, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	return serverRepository.IsServerMember(serverID, userID)
+}
+
+// IsServerOwner reports whether the user owns the server
+func (service *PermissionService) IsServerOwner(serverID uint, userID uint) (bool, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	server, err := serverRepository.GetServer(fmt.Sprintf("%d", serverID))
+	if err != nil {
+		return false, err
+	}
+	return server.UserID == userID, nil
+}
+
+// HasServerPermission checks that the user holds every bit of permission in the server
+func (service *PermissionService) HasServerPermission(serverID uint, userID uint, permission int64) (bool, error) {
+	permissions, err := service.GetServerPermissions(serverID, userID)
+	if err != nil {
+		return false, err
+	}
+	return permissions&permission == permission, nil
+}
+
+// HasChannelPermission checks that the user holds every bit of permission in the channel
+func (service *PermissionService) HasChannelPermission(channelID uint, userID uint, permission int64) (bool, error) {
+	permissions, err := service.GetChannelPermissions(channelID, userID)
+	if err != nil {
+		return false, err
+	}
+	return permissions&permission == permission, nil
+}
+
+// CanAccessChannel checks that the user can view the channel
+func (service *PermissionService) CanAccessChannel(channelID uint, userID uint) (bool, error) {
+	return service.HasChannelPermission(channelID, userID, entity.PermissionViewChannel)
+}
+
+// IsValidOverwriteTarget checks that the target of a channel overwrite belongs to the server:
+// a role of the server or one of its members
+func (service *PermissionService) IsValidOverwriteTarget(serverID uint, targetType string, targetID uint) (bool, error) {
+	switch targetType {
+	case entity.OverwriteEveryone:
+		return true, nil
+	case entity.OverwriteRole:
+		roleRepository := repositories.NewRoleRepository(service.DB)
+		role, err := roleRepository.GetRole(targetID)
+		if err == gorm.ErrRecordNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return role.ServerID == serverID, nil
+	case entity.OverwriteMember:
+		return service.IsServerMember(serverID, targetID)
+	}
+	return false, nil
+}
+
+// GetAccessibleChannels keeps the channels the user can view
+func (service *PermissionService) GetAccessibleChannels(channels []entity.Channel, userID uint) ([]entity.Channel, error) {
+	accessible := make([]entity.Channel, 0, len(channels))
+	for _, channel := range channels {
+		allowed, err := service.CanAccessChannel(channel.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			accessible = append(accessible, channel)
+		}
+	}
+	return accessible, nil
+}
+
+// CanViewRevisions checks that the user wrote the message or can manage messages in its channel
+func (service *PermissionService) CanViewRevisions(message *entity.Message, userID uint) (bool, error) {
+	return service.CanDeleteMessage(message, userID)
+}
+
+// CanDeleteMessage checks that the user wrote the message or can manage messages in its channel
+func (service *PermissionService) CanDeleteMessage(message *entity.Message, userID uint) (bool, error) {
+	if message.UserID == userID {
+		return service.CanAccessChannel(message.ChannelID, userID)
+	}
+	return service.HasChannelPermission(message.ChannelID, userID, entity.PermissionViewChannel|entity.PermissionManageMessages)
+}
+
+// CanModerate checks that the moderator is ranked above the target in the server.
+// Nobody can moderate the owner or themselves, and the owner can moderate everyone else.
+func (service *PermissionService) CanModerate(serverID uint, moderatorID uint, targetID uint) (bool, error) {
+	if moderatorID == targetID {
+		return false, nil
+	}
+
+	serverRepository := repositories.NewServerRepository(service.DB)
+	server, err := serverRepository.GetServer(fmt.Sprintf("%d", serverID))
+	if err != nil {
+		return false, err
+	}
+	if server.UserID == targetID {
+		return false, nil
+	}
+	if server.UserID == moderatorID {
+		return true, nil
+	}
+
+	moderatorPosition, err := service.highestRolePosition(serverID, moderatorID)
+	if err != nil {
+		return false, err
+	}
+	targetPosition, err := service.highestRolePosition(serverID, targetID)
+	if err != nil {
+		return false, err
+	}
+	return moderatorPosition > targetPosition, nil
+}
+
+// CanManageRole checks that a role at position is ranked below the highest role of the user,
+// so that nobody can create, change or hand out a role that outranks them. The owner can
+// manage every role.
+func (service *PermissionService) CanManageRole(serverID uint, userID uint, position int) (bool, error) {
+	owner, err := service.IsServerOwner(serverID, userID)
+	if err != nil || owner {
+		return owner, err
+	}
+
+	highestPosition, err := service.highestRolePosition(serverID, userID)
+	if err != nil {
+		return false, err
+	}
+	return position < highestPosition, nil
+}
+
+func (service *PermissionService) highestRolePosition(serverID uint, userID uint) (int, error) {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	roles, err := roleRepository.GetMemberRoles(serverID, userID)
+	if err != nil {
+		return 0, err
+	}
+	// Roles are ordered by position, members without roles are at the bottom
+	if len(roles) == 0 {
+		return -1, nil
+	}
+	return roles[0].Position, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+var ErrInvalidStatus = errors.New("status must be online, idle, dnd or invisible")
+
+type PresenceService struct {
+	DB *gorm.DB
+}
+
+func NewPresenceService(db *gorm.DB) *PresenceService {
+	return &PresenceService{DB: db}
+}
+
+// SetStatus saves the status chosen by the user, it applies to all their connections
+func (service *PresenceService) SetStatus(userID uint, status string) error {
+	if !entity.IsValidStatus(status) {
+		return ErrInvalidStatus
+	}
+	userRepository := repositories.NewUserRepository(service.DB)
+	return userRepository.UpdateUserStatus(userID, status)
+}
+
+// UpdateLastSeen records when the user closed their last connection
+func (service *PresenceService) UpdateLastSeen(userID uint, lastSeenAt time.Time) error {
+	userRepository := repositories.NewUserRepository(service.DB)
+	return userRepository.UpdateLastSeen(userID, lastSeenAt)
+}
+
+// GetPresenceAudience returns the users who can see the presence of the user: their
+// friends and the members of the servers they share, except the users they blocked
+func (service *PresenceService) GetPresenceAudience(userID uint) ([]uint, error) {
+	userRepository := repositories.NewUserRepository(service.DB)
+	userIDs, err := userRepository.GetRelatedUserIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := NewFriendshipService(service.DB).GetBlockedIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	audience := make([]uint, 0, len(userIDs))
+	for _, relatedID := range userIDs {
+		if !blocked[relatedID] {
+			audience = append(audience, relatedID)
+		}
+	}
+	return audience, nil
+}
+
+// GetVisibleUserIDs returns the users whose presence the user can see: their friends and
+// the members of the servers they share, except the users who blocked them
+func (service *PresenceService) GetVisibleUserIDs(userID uint) ([]uint, error) {
+	userRepository := repositories.NewUserRepository(service.DB)
+	userIDs, err := userRepository.GetRelatedUserIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	blockers, err := NewFriendshipService(service.DB).GetBlockerIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]uint, 0, len(userIDs))
+	for _, relatedID := range userIDs {
+		if !blockers[relatedID] {
+			visible = append(visible, relatedID)
+		}
+	}
+	return visible, nil
+}
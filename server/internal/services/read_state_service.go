@@ -0,0 +1,217 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+var ErrMessageNotInChannel = errors.New("message is not in this channel")
+
+type ReadStateService struct {
+	DB *gorm.DB
+}
+
+func NewReadStateService(db *gorm.DB) *ReadStateService {
+	return &ReadStateService{DB: db}
+}
+
+// AckChannel marks a channel as read up to a message, or up to its latest message
+// when messageID is 0, and returns the acknowledged message ID
+func (service *ReadStateService) AckChannel(userID uint, channelID uint, messageID uint) (uint, error) {
+	readStateRepository := repositories.NewReadStateRepository(service.DB)
+	if messageID == 0 {
+		latest, err := readStateRepository.GetLatestMessageID(channelID)
+		if err != nil {
+			return 0, err
+		}
+		messageID = latest
+	} else {
+		messageRepository := repositories.NewMessageRepository(service.DB)
+		message, err := messageRepository.GetMessage(fmt.Sprintf("%d", messageID))
+		if err != nil || message.ChannelID != channelID {
+			return 0, ErrMessageNotInChannel
+		}
+	}
+
+	err := readStateRepository.SaveReadState(&entity.ReadState{
+		UserID:        userID,
+		ChannelID:     channelID,
+		LastMessageID: messageID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return messageID, nil
+}
+
+// GetChannelsWithReadState adds the read state of the user to each channel
+func (service *ReadStateService) GetChannelsWithReadState(userID uint, channels []entity.Channel) ([]entity.ChannelWithReadState, error) {
+	channelIDs := make([]uint, len(channels))
+	for i, channel := range channels {
+		channelIDs[i] = channel.ID
+	}
+
+	lastRead, unread, mentions, err := service.getReadState(userID, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]entity.ChannelWithReadState, len(channels))
+	for i, channel := range channels {
+		result[i] = entity.ChannelWithReadState{
+			Channel:           channel,
+			LastReadMessageID: lastRead[channel.ID],
+			UnreadCount:       unread[channel.ID],
+			MentionCount:      mentions[channel.ID],
+		}
+	}
+	return result, nil
+}
+
+// GetChannelReadStates adds to a channel the read state of each of the users, querying the
+// read states of all of them at once
+func (service *ReadStateService) GetChannelReadStates(channel entity.Channel, userIDs []uint) (map[uint]entity.ChannelWithReadState, error) {
+	result := make(map[uint]entity.ChannelWithReadState, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	readStateRepository := repositories.NewReadStateRepository(service.DB)
+	lastRead, err := readStateRepository.GetChannelReadStates(channel.ID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := readStateRepository.CountChannelUnread(channel.ID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	mentions, err := readStateRepository.CountChannelUnreadMentions(channel.ID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, userID := range userIDs {
+		result[userID] = entity.ChannelWithReadState{
+			Channel:           channel,
+			LastReadMessageID: lastRead[userID],
+			UnreadCount:       unread[userID],
+			MentionCount:      mentions[userID],
+		}
+	}
+	return result, nil
+}
+
+// GetServersWithReadState adds to each server the unread messages and mentions
+// of the user across the channels they can view
+func (service *ReadStateService) GetServersWithReadState(userID uint, servers []entity.Server) ([]entity.ServerWithReadState, error) {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	permissionService := NewPermissionService(service.DB)
+
+	serverChannels := make(map[uint][]uint, len(servers))
+	var channelIDs []uint
+	for _, server := range servers {
+		channels, err := channelRepository.GetServerChannels(fmt.Sprintf("%d", server.ID))
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			allowed, err := permissionService.CanAccessChannel(channel.ID, userID)
+			if err != nil {
+				return nil, err
+			}
+			if allowed {
+				serverChannels[server.ID] = append(serverChannels[server.ID], channel.ID)
+				channelIDs = append(channelIDs, channel.ID)
+			}
+		}
+	}
+
+	_, unread, mentions, err := service.getReadState(userID, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]entity.ServerWithReadState, len(servers))
+	for i, server := range servers {
+		result[i] = entity.ServerWithReadState{Server: server}
+		for _, channelID := range serverChannels[server.ID] {
+			result[i].UnreadCount += unread[channelID]
+			result[i].MentionCount += mentions[channelID]
+		}
+	}
+	return result, nil
+}
+
+// GetServersWithChannels returns the servers of the user along with the channels they can view
+// and their read state
+func (service *ReadStateService) GetServersWithChannels(userID uint) ([]entity.ServerWithChannels, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	servers, err := serverRepository.GetUserServers(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]entity.ServerWithChannels, len(servers))
+	for i, server := range servers {
+		serverWithChannels, err := service.GetServerWithChannels(userID, server)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = *serverWithChannels
+	}
+	return result, nil
+}
+
+// GetServerWithChannels adds to a server the channels the user can view with their read state,
+// and the unread messages and mentions across them
+func (service *ReadStateService) GetServerWithChannels(userID uint, server entity.Server) (*entity.ServerWithChannels, error) {
+	channelRepository := repositories.NewChannelRepository(service.DB)
+	channels, err := channelRepository.GetServerChannels(fmt.Sprintf("%d", server.ID))
+	if err != nil {
+		return nil, err
+	}
+	channels, err = NewPermissionService(service.DB).GetAccessibleChannels(channels, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	channelsWithReadState, err := service.GetChannelsWithReadState(userID, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &entity.ServerWithChannels{
+		ServerWithReadState: entity.ServerWithReadState{Server: server},
+		Channels:            channelsWithReadState,
+	}
+	for _, channel := range channelsWithReadState {
+		result.UnreadCount += channel.UnreadCount
+		result.MentionCount += channel.MentionCount
+	}
+	return result, nil
+}
+
+func (service *ReadStateService) getReadState(userID uint, channelIDs []uint) (map[uint]uint, map[uint]int, map[uint]int, error) {
+	if len(channelIDs) == 0 {
+		return map[uint]uint{}, map[uint]int{}, map[uint]int{}, nil
+	}
+
+	readStateRepository := repositories.NewReadStateRepository(service.DB)
+	lastRead, err := readStateRepository.GetReadStates(userID, channelIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	unread, err := readStateRepository.CountUnread(userID, channelIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mentions, err := readStateRepository.CountUnreadMentions(userID, channelIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return lastRead, unread, mentions, nil
+}
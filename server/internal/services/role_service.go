@@ -0,0 +1,55 @@
+package services
+
+import (
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+type RoleService struct {
+	DB *gorm.DB
+}
+
+func NewRoleService(db *gorm.DB) *RoleService {
+	return &RoleService{DB: db}
+}
+
+func (service *RoleService) CreateRole(role *entity.Role) error {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.CreateRole(role)
+}
+
+func (service *RoleService) GetRole(roleId uint) (*entity.Role, error) {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.GetRole(roleId)
+}
+
+func (service *RoleService) GetServerRoles(serverId string) ([]entity.Role, error) {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.GetServerRoles(serverId)
+}
+
+func (service *RoleService) UpdateRole(role *entity.Role) error {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.UpdateRole(role)
+}
+
+func (service *RoleService) DeleteRole(role *entity.Role) error {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.DeleteRole(role)
+}
+
+func (service *RoleService) AssignRole(serverID uint, userID uint, roleID uint) error {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.AssignRole(serverID, userID, roleID)
+}
+
+func (service *RoleService) RemoveRole(serverID uint, userID uint, roleID uint) error {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.RemoveRole(serverID, userID, roleID)
+}
+
+func (service *RoleService) GetMemberRoles(serverID uint, userID uint) ([]entity.Role, error) {
+	roleRepository := repositories.NewRoleRepository(service.DB)
+	return roleRepository.GetMemberRoles(serverID, userID)
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+// SearchQuery selects the indexed messages matching every term and filter that is set
+type SearchQuery struct {
+	Terms      []string
+	ChannelIDs []uint // Required, messages from other channels are never returned
+	AuthorIDs  []uint
+	MentionIDs []uint
+	Has        []string // Media types: image, video or audio
+	Pinned     *bool
+	Before     *time.Time
+	After      *time.Time
+	Offset     int
+	Limit      int
+}
+
+// SearchIndex stores messages so that they can be searched by content and filters.
+// Results are message IDs, newest first, along with the total number of matches.
+type SearchIndex interface {
+	Index(message *entity.Message) error
+	Remove(messageID uint) error
+	Search(query SearchQuery) ([]uint, int, error)
+}
+
+var searchIndex SearchIndex = NewMemorySearchIndex()
+
+// SetSearchIndex replaces the search index used by the services, before any message is indexed.
+// The built-in memory index only sees the messages written through its own instance, so several
+// instances must share an index such as the DatabaseSearchIndex.
+func SetSearchIndex(index SearchIndex) {
+	searchIndex = index
+}
+
+// DatabaseSearchIndex stores the terms of each message in the message_terms table, so that
+// every instance sees the same messages. Content is split into terms by the same tokenize as
+// the MemorySearchIndex, so both match whole words only.
+type DatabaseSearchIndex struct {
+	DB *gorm.DB
+}
+
+func NewDatabaseSearchIndex(db *gorm.DB) *DatabaseSearchIndex {
+	return &DatabaseSearchIndex{DB: db}
+}
+
+// Index replaces the stored terms of a message, the other filters are read from the messages
+func (index *DatabaseSearchIndex) Index(message *entity.Message) error {
+	messageRepository := repositories.NewMessageRepository(index.DB)
+	return messageRepository.SetMessageTerms(message.ID, tokenize(message.Content))
+}
+
+// Remove drops the stored terms of a message
+func (index *DatabaseSearchIndex) Remove(messageID uint) error {
+	messageRepository := repositories.NewMessageRepository(index.DB)
+	return messageRepository.SetMessageTerms(messageID, nil)
+}
+
+// IndexMissing stores the terms of the messages written before the index was in use
+func (index *DatabaseSearchIndex) IndexMissing() error {
+	messageRepository := repositories.NewMessageRepository(index.DB)
+	return messageRepository.FindUnindexedMessagesInBatches(indexBatchSize, func(messages []entity.Message) error {
+		for i := range messages {
+			if err := index.Index(&messages[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (index *DatabaseSearchIndex) Search(query SearchQuery) ([]uint, int, error) {
+	messageRepository := repositories.NewMessageRepository(index.DB)
+	messageIDs, total, err := messageRepository.SearchMessages(repositories.MessageSearch{
+		Terms:      tokenize(strings.Join(query.Terms, " ")),
+		ChannelIDs: query.ChannelIDs,
+		AuthorIDs:  query.AuthorIDs,
+		MentionIDs: query.MentionIDs,
+		Has:        query.Has,
+		Pinned:     query.Pinned,
+		Before:     query.Before,
+		After:      query.After,
+		Offset:     query.Offset,
+		Limit:      query.Limit,
+	})
+	return messageIDs, int(total), err
+}
+
+// indexedMessage is the searchable part of a message
+type indexedMessage struct {
+	ID         uint
+	ChannelID  uint
+	AuthorID   uint
+	MentionIDs map[uint]bool
+	Has        map[string]bool
+	Pinned     bool
+	CreatedAt  time.Time
+	Terms      []string
+}
+
+// MemorySearchIndex is the built-in search index, an inverted index of message terms kept in memory
+type MemorySearchIndex struct {
+	mutex    sync.RWMutex
+	messages map[uint]*indexedMessage
+	postings map[string]map[uint]bool
+}
+
+func NewMemorySearchIndex() *MemorySearchIndex {
+	return &MemorySearchIndex{
+		messages: make(map[uint]*indexedMessage),
+		postings: make(map[string]map[uint]bool),
+	}
+}
+
+// Index adds a message to the index or replaces its previous version
+func (index *MemorySearchIndex) Index(message *entity.Message) error {
+	indexed := &indexedMessage{
+		ID:         message.ID,
+		ChannelID:  message.ChannelID,
+		AuthorID:   message.UserID,
+		MentionIDs: make(map[uint]bool),
+		Has:        make(map[string]bool),
+		Pinned:     message.Pinned,
+		CreatedAt:  message.CreatedAt,
+		Terms:      tokenize(message.Content),
+	}
+	for _, mention := range message.Mentions {
+		if mention.Type == entity.MentionUser {
+			indexed.MentionIDs[mention.TargetID] = true
+		}
+	}
+	for _, media := range message.Medias {
+		indexed.Has[media.Type] = true
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.remove(message.ID)
+	index.messages[message.ID] = indexed
+	for _, term := range indexed.Terms {
+		if _, ok := index.postings[term]; !ok {
+			index.postings[term] = make(map[uint]bool)
+		}
+		index.postings[term][message.ID] = true
+	}
+	return nil
+}
+
+// Remove drops a message from the index
+func (index *MemorySearchIndex) Remove(messageID uint) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.remove(messageID)
+	return nil
+}
+
+func (index *MemorySearchIndex) remove(messageID uint) {
+	indexed, ok := index.messages[messageID]
+	if !ok {
+		return
+	}
+	for _, term := range indexed.Terms {
+		delete(index.postings[term], messageID)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	delete(index.messages, messageID)
+}
+
+// Search returns the IDs of a page of the matching messages, newest first, and the number of matches
+func (index *MemorySearchIndex) Search(query SearchQuery) ([]uint, int, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	channels := idSet(query.ChannelIDs)
+	authors := idSet(query.AuthorIDs)
+
+	var candidates map[uint]bool
+	terms := tokenize(strings.Join(query.Terms, " "))
+	for _, term := range terms {
+		postings := index.postings[term]
+		if candidates == nil {
+			candidates = make(map[uint]bool, len(postings))
+			for messageID := range postings {
+				candidates[messageID] = true
+			}
+			continue
+		}
+		for messageID := range candidates {
+			if !postings[messageID] {
+				delete(candidates, messageID)
+			}
+		}
+	}
+
+	var matches []uint
+	for messageID, indexed := range index.messages {
+		if len(terms) > 0 && !candidates[messageID] {
+			continue
+		}
+		if !channels[indexed.ChannelID] {
+			continue
+		}
+		if len(authors) > 0 && !authors[indexed.AuthorID] {
+			continue
+		}
+		if !indexed.matches(query) {
+			continue
+		}
+		matches = append(matches, messageID)
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+	total := len(matches)
+	if query.Offset >= total {
+		return []uint{}, total, nil
+	}
+	matches = matches[query.Offset:]
+	if query.Limit > 0 && len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+	return matches, total, nil
+}
+
+// matches checks the filters of the query that are not about channels and authors
+func (indexed *indexedMessage) matches(query SearchQuery) bool {
+	for _, mentionID := range query.MentionIDs {
+		if !indexed.MentionIDs[mentionID] {
+			return false
+		}
+	}
+	for _, mediaType := range query.Has {
+		if !indexed.Has[mediaType] {
+			return false
+		}
+	}
+	if query.Pinned != nil && indexed.Pinned != *query.Pinned {
+		return false
+	}
+	if query.Before != nil && !indexed.CreatedAt.Before(*query.Before) {
+		return false
+	}
+	if query.After != nil && !indexed.CreatedAt.After(*query.After) {
+		return false
+	}
+	return true
+}
+
+// idSet turns a list of IDs into a set
+func idSet(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// maxTermLength is the number of characters of a term that are indexed and searched, longer
+// words are cut so that they fit the message_terms table
+const maxTermLength = 64
+
+// tokenize splits content into lowercase words, each word once
+func tokenize(content string) []string {
+	words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	seen := make(map[string]bool, len(words))
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if runes := []rune(word); len(runes) > maxTermLength {
+			word = string(runes[:maxTermLength])
+		}
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
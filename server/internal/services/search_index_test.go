@@ -0,0 +1,153 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+)
+
+var searchEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestMessage(id uint, channelID uint, authorID uint, content string, day int) *entity.Message {
+	return &entity.Message{
+		Model:     gorm.Model{ID: id, CreatedAt: searchEpoch.AddDate(0, 0, day)},
+		ChannelID: channelID,
+		UserID:    authorID,
+		Content:   content,
+	}
+}
+
+func newTestSearchIndex(t *testing.T) *MemorySearchIndex {
+	t.Helper()
+
+	withImage := newTestMessage(3, 10, 2, "Look at this cat picture", 3)
+	withImage.Medias = []entity.Media{{Type: "image"}}
+	withMention := newTestMessage(4, 20, 1, "hey, the cat is back", 4)
+	withMention.Mentions = []entity.Mention{{Type: entity.MentionUser, TargetID: 2}}
+	pinned := newTestMessage(5, 10, 1, "Meeting notes", 5)
+	pinned.Pinned = true
+
+	index := NewMemorySearchIndex()
+	for _, message := range []*entity.Message{
+		newTestMessage(1, 10, 1, "Hello world", 1),
+		newTestMessage(2, 10, 2, "hello CAT", 2),
+		withImage,
+		withMention,
+		pinned,
+	} {
+		if err := index.Index(message); err != nil {
+			t.Fatalf("Index(%d) failed: %v", message.ID, err)
+		}
+	}
+	return index
+}
+
+func TestMemorySearchIndexSearch(t *testing.T) {
+	index := newTestSearchIndex(t)
+	yes := true
+	no := false
+	before := searchEpoch.AddDate(0, 0, 3)
+	after := searchEpoch.AddDate(0, 0, 2)
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []uint
+	}{
+		{"every message of the channels", SearchQuery{ChannelIDs: []uint{10, 20}}, []uint{5, 4, 3, 2, 1}},
+		{"single term ignores case", SearchQuery{Terms: []string{"HELLO"}, ChannelIDs: []uint{10, 20}}, []uint{2, 1}},
+		{"every term must match", SearchQuery{Terms: []string{"hello", "cat"}, ChannelIDs: []uint{10, 20}}, []uint{2}},
+		{"whole words only", SearchQuery{Terms: []string{"ca"}, ChannelIDs: []uint{10, 20}}, nil},
+		{"punctuation splits terms", SearchQuery{Terms: []string{"hey,"}, ChannelIDs: []uint{10, 20}}, []uint{4}},
+		{"unknown term", SearchQuery{Terms: []string{"dog"}, ChannelIDs: []uint{10, 20}}, nil},
+		{"channel", SearchQuery{Terms: []string{"cat"}, ChannelIDs: []uint{20}}, []uint{4}},
+		{"no channel", SearchQuery{Terms: []string{"cat"}}, nil},
+		{"author", SearchQuery{ChannelIDs: []uint{10, 20}, AuthorIDs: []uint{2}}, []uint{3, 2}},
+		{"mention", SearchQuery{ChannelIDs: []uint{10, 20}, MentionIDs: []uint{2}}, []uint{4}},
+		{"has image", SearchQuery{ChannelIDs: []uint{10, 20}, Has: []string{"image"}}, []uint{3}},
+		{"has video", SearchQuery{ChannelIDs: []uint{10, 20}, Has: []string{"video"}}, nil},
+		{"pinned", SearchQuery{ChannelIDs: []uint{10, 20}, Pinned: &yes}, []uint{5}},
+		{"not pinned", SearchQuery{ChannelIDs: []uint{10}, Pinned: &no}, []uint{3, 2, 1}},
+		{"before", SearchQuery{ChannelIDs: []uint{10, 20}, Before: &before}, []uint{2, 1}},
+		{"after", SearchQuery{ChannelIDs: []uint{10, 20}, After: &after}, []uint{5, 4, 3}},
+		{"before and after", SearchQuery{ChannelIDs: []uint{10, 20}, Before: &before, After: &after}, nil},
+		{"filters and terms", SearchQuery{Terms: []string{"cat"}, ChannelIDs: []uint{10}, AuthorIDs: []uint{2}, Has: []string{"image"}}, []uint{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, total, err := index.Search(test.query)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if len(got) == 0 {
+				got = nil
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Search returned %v, want %v", got, test.want)
+			}
+			if total != len(test.want) {
+				t.Errorf("Search counted %d matches, want %d", total, len(test.want))
+			}
+		})
+	}
+}
+
+func TestMemorySearchIndexPagination(t *testing.T) {
+	index := newTestSearchIndex(t)
+
+	got, total, err := index.Search(SearchQuery{ChannelIDs: []uint{10, 20}, Offset: 1, Limit: 2})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, []uint{4, 3}) || total != 5 {
+		t.Errorf("Search returned %v out of %d, want [4 3] out of 5", got, total)
+	}
+
+	got, total, err = index.Search(SearchQuery{ChannelIDs: []uint{10, 20}, Offset: 5})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(got) != 0 || total != 5 {
+		t.Errorf("Search returned %v out of %d past the last page, want nothing out of 5", got, total)
+	}
+}
+
+func TestMemorySearchIndexUpdates(t *testing.T) {
+	index := newTestSearchIndex(t)
+	query := SearchQuery{Terms: []string{"hello"}, ChannelIDs: []uint{10}}
+
+	edited := newTestMessage(1, 10, 1, "Goodbye world", 1)
+	if err := index.Index(edited); err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	if got, _, _ := index.Search(query); !reflect.DeepEqual(got, []uint{2}) {
+		t.Errorf("Search after an edit returned %v, want [2]", got)
+	}
+	if got, _, _ := index.Search(SearchQuery{Terms: []string{"goodbye"}, ChannelIDs: []uint{10}}); !reflect.DeepEqual(got, []uint{1}) {
+		t.Errorf("Search for the new content returned %v, want [1]", got)
+	}
+
+	if err := index.Remove(2); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if got, _, _ := index.Search(query); len(got) != 0 {
+		t.Errorf("Search after a removal returned %v, want nothing", got)
+	}
+	if _, ok := index.postings["hello"]; ok {
+		t.Errorf("postings of a term without messages were kept")
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	long := strings.Repeat("a", maxTermLength+10)
+	got := tokenize("Hello, hello WORLD! l'été " + long)
+	want := []string{"hello", "world", "l", "été", long[:maxTermLength]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize returned %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+const (
+	DefaultSearchLimit = 25
+	MaxSearchLimit     = 100
+	indexBatchSize     = 500
+)
+
+var ErrInvalidMediaFilter = errors.New("has must be image, video or audio")
+
+type SearchService struct {
+	DB *gorm.DB
+}
+
+func NewSearchService(db *gorm.DB) *SearchService {
+	return &SearchService{DB: db}
+}
+
+// RebuildIndex indexes every message, the built-in index is only kept in memory
+func (service *SearchService) RebuildIndex() error {
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	return messageRepository.FindMessagesInBatches(indexBatchSize, func(messages []entity.Message) error {
+		for i := range messages {
+			if err := searchIndex.Index(&messages[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SearchMessages searches the messages of a server, newest first. Only the channels of
+// the server the user can view are searched, whatever channels the query asks for.
+func (service *SearchService) SearchMessages(serverID uint, userID uint, query SearchQuery) (*entity.MessageSearchResponse, error) {
+	for _, mediaType := range query.Has {
+		if mediaType != "image" && mediaType != "video" && mediaType != "audio" {
+			return nil, ErrInvalidMediaFilter
+		}
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	}
+	if query.Limit > MaxSearchLimit {
+		query.Limit = MaxSearchLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	channelIDs, err := service.getReadableChannelIDs(serverID, userID, query.ChannelIDs)
+	if err != nil {
+		return nil, err
+	}
+	response := &entity.MessageSearchResponse{Messages: []entity.MessageResponse{}}
+	if len(channelIDs) == 0 {
+		return response, nil
+	}
+	query.ChannelIDs = channelIDs
+
+	messageIDs, total, err := searchIndex.Search(query)
+	if err != nil {
+		return nil, err
+	}
+	response.TotalResults = total
+	if len(messageIDs) == 0 {
+		return response, nil
+	}
+
+	messageRepository := repositories.NewMessageRepository(service.DB)
+	messages, err := messageRepository.GetMessagesByIDs(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	response.Messages, err = NewMessageService(service.DB).toResponses(messages, userID)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// getReadableChannelIDs returns the channels of the server the user can view, limited to
+// the requested channels when there are any
+func (service *SearchService) getReadableChannelIDs(serverID uint, userID uint, requested []uint) ([]uint, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	channels, err := serverRepository.GetServerChannels(fmt.Sprintf("%d", serverID))
+	if err != nil {
+		return nil, err
+	}
+	requestedSet := idSet(requested)
+
+	permissionService := NewPermissionService(service.DB)
+	var channelIDs []uint
+	for _, channel := range channels {
+		if len(requested) > 0 && !requestedSet[channel.ID] {
+			continue
+		}
+		allowed, err := permissionService.CanAccessChannel(channel.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			channelIDs = append(channelIDs, channel.ID)
+		}
+	}
+	return channelIDs, nil
+}
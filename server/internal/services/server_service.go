@@ -1,6 +1,9 @@
 package services
 
 import (
+	"strings"
+	"unicode/utf8"
+
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/repositories"
@@ -39,6 +42,11 @@ func (service *ServerService) UpdateServer(server *entity.Server) error {
 	return serverRepository.UpdateServer(server)
 }
 
+func (service *ServerService) TransferOwnership(server *entity.Server, userID uint) error {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	return serverRepository.UpdateServerOwner(server, userID)
+}
+
 func (service *ServerService) DeleteServer(server *entity.Server) error {
 	serverRepository := repositories.NewServerRepository(service.DB)
 	return serverRepository.DeleteServer(server)
@@ -59,6 +67,71 @@ func (service *ServerService) AddUserToServer(serverID uint, userID uint) error
 	return serverRepository.AddUserToServer(serverID, userID)
 }
 
+func (service *ServerService) RemoveUserFromServer(serverID uint, userID uint) error {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	return serverRepository.RemoveUserFromServer(serverID, userID)
+}
+
+// RemoveTemporaryMemberships removes the user from every server they joined with a temporary
+// invite and returns the IDs of the servers the user was removed from
+func (service *ServerService) RemoveTemporaryMemberships(userID uint) ([]uint, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	serverIDs, err := serverRepository.GetTemporaryServerIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	removed := make([]uint, 0, len(serverIDs))
+	for _, serverID := range serverIDs {
+		if err := serverRepository.RemoveUserFromServer(serverID, userID); err != nil {
+			return removed, err
+		}
+		removed = append(removed, serverID)
+	}
+	return removed, nil
+}
+
+func (service *ServerService) GetServerMemberIDs(serverID uint) ([]uint, error) {
+	serverRepository := repositories.NewServerRepository(service.DB)
+	return serverRepository.GetServerMemberIDs(serverID)
+}
+
+// SetNickname sets the nickname of a member in a server, an empty nickname clears it
+func (service *ServerService) SetNickname(serverID uint, userID uint, nickname string) error {
+	nickname = strings.TrimSpace(nickname)
+	if utf8.RuneCountInString(nickname) > MaxNicknameLength {
+		return ErrNicknameTooLong
+	}
+
+	serverRepository := repositories.NewServerRepository(service.DB)
+	if _, err := serverRepository.GetMembership(serverID, userID); err != nil {
+		return err
+	}
+	return serverRepository.SetMemberNickname(serverID, userID, nickname)
+}
+
+// GetMemberNicknames maps the users who set a nickname in the server to their nickname
+func (service *ServerService) GetMemberNicknames(serverID uint, userIDs []uint) (map[uint]string, error) {
+	nicknames := make(map[uint]string)
+	if len(userIDs) == 0 {
+		return nicknames, nil
+	}
+
+	serverRepository := repositories.NewServerRepository(service.DB)
+	memberships, err := serverRepository.GetMemberNicknames(serverID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, membership := range memberships {
+		nicknames[membership.UserID] = membership.Nickname
+	}
+	return nicknames, nil
+}
+
+func (service *ServerService) IsUserBanned(serverID uint, userID uint) (bool, error) {
+	moderationService := NewModerationService(service.DB)
+	return moderationService.IsBanned(serverID, userID)
+}
+
 func (service *ServerService) GetUserByEmail(email string) (*entity.User, error) {
 	userRepository := repositories.NewUserRepository(service.DB)
 	return userRepository.GetUserByEmail(email)
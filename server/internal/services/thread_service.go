@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/repositories"
+)
+
+// DefaultAutoArchiveDuration archives threads after a day without messages
+const DefaultAutoArchiveDuration = 24 * 60
+
+// AutoArchiveDurations are the allowed minutes of inactivity before a thread is archived
+var AutoArchiveDurations = []int{60, 24 * 60, 3 * 24 * 60, 7 * 24 * 60}
+
+var (
+	ErrThreadExists   = errors.New("message already has a thread")
+	ErrNestedThread   = errors.New("threads cannot be started from a message in a thread")
+	ErrInvalidArchive = errors.New("invalid auto archive duration")
+)
+
+type ThreadService struct {
+	DB *gorm.DB
+}
+
+func NewThreadService(db *gorm.DB) *ThreadService {
+	return &ThreadService{DB: db}
+}
+
+// CreateThread starts a thread from a message, the creator becomes its first member
+func (service *ThreadService) CreateThread(message *entity.Message, userID uint, name string, autoArchiveDuration int) (*entity.Thread, error) {
+	if message.ThreadID != nil {
+		return nil, ErrNestedThread
+	}
+	if autoArchiveDuration == 0 {
+		autoArchiveDuration = DefaultAutoArchiveDuration
+	}
+	if !IsValidAutoArchiveDuration(autoArchiveDuration) {
+		return nil, ErrInvalidArchive
+	}
+
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	if _, err := threadRepository.GetThreadByStarterMessage(message.ID); err == nil {
+		return nil, ErrThreadExists
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	thread := entity.Thread{
+		ChannelID:           message.ChannelID,
+		StarterMessageID:    message.ID,
+		UserID:              userID,
+		Name:                name,
+		AutoArchiveDuration: autoArchiveDuration,
+		LastMessageAt:       time.Now(),
+	}
+	err := service.DB.Transaction(func(tx *gorm.DB) error {
+		threadRepository := repositories.NewThreadRepository(tx)
+		if err := threadRepository.CreateThread(&thread); err != nil {
+			return err
+		}
+		return threadRepository.AddThreadMember(thread.ID, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return threadRepository.GetThread(thread.ID)
+}
+
+// GetThread returns a thread, archiving it first if it has been inactive for too long
+func (service *ThreadService) GetThread(threadId uint) (*entity.Thread, error) {
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	thread, err := threadRepository.GetThread(threadId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !thread.Archived && isInactive(thread, time.Now()) {
+		thread.Archived = true
+		if err := threadRepository.UpdateThread(thread); err != nil {
+			return nil, err
+		}
+	}
+	return thread, nil
+}
+
+// GetChannelThreads returns the threads of a channel, active ones first
+func (service *ThreadService) GetChannelThreads(channelID uint) ([]entity.ThreadResponse, error) {
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	if err := threadRepository.ArchiveInactiveThreads(channelID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	threads, err := threadRepository.GetChannelThreads(fmt.Sprintf("%d", channelID))
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]entity.ThreadResponse, len(threads))
+	for i, thread := range threads {
+		responses[i] = thread.ToResponse()
+	}
+	return responses, nil
+}
+
+func (service *ThreadService) UpdateThread(thread *entity.Thread) error {
+	if !IsValidAutoArchiveDuration(thread.AutoArchiveDuration) {
+		return ErrInvalidArchive
+	}
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	return threadRepository.UpdateThread(thread)
+}
+
+// AttachMessage moves a message being created into a thread, its channel becomes the thread's channel
+func (service *ThreadService) AttachMessage(message *entity.Message, threadID uint) (*entity.Thread, error) {
+	thread, err := service.GetThread(threadID)
+	if err != nil {
+		return nil, err
+	}
+	message.ChannelID = thread.ChannelID
+	message.ThreadID = &thread.ID
+	return thread, nil
+}
+
+// RecordMessage unarchives the thread and adds the author of a new message to its members
+func (service *ThreadService) RecordMessage(thread *entity.Thread, message *entity.Message) error {
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	if err := threadRepository.RecordMessage(thread, message.CreatedAt); err != nil {
+		return err
+	}
+	return threadRepository.AddThreadMember(thread.ID, message.UserID)
+}
+
+func (service *ThreadService) AddThreadMember(threadID uint, userID uint) error {
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	return threadRepository.AddThreadMember(threadID, userID)
+}
+
+func (service *ThreadService) RemoveThreadMember(threadID uint, userID uint) error {
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	return threadRepository.RemoveThreadMember(threadID, userID)
+}
+
+func (service *ThreadService) GetThreadMembers(threadID uint) ([]entity.UserResponse, error) {
+	threadRepository := repositories.NewThreadRepository(service.DB)
+	members, err := threadRepository.GetThreadMembers(threadID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]entity.UserResponse, len(members))
+	for i, member := range members {
+		responses[i] = member.ToResponse()
+	}
+	return responses, nil
+}
+
+// IsValidAutoArchiveDuration reports whether the duration is one of AutoArchiveDurations
+func IsValidAutoArchiveDuration(duration int) bool {
+	for _, allowed := range AutoArchiveDurations {
+		if duration == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func isInactive(thread *entity.Thread, now time.Time) bool {
+	return thread.LastMessageAt.Add(time.Duration(thread.AutoArchiveDuration) * time.Minute).Before(now)
+}
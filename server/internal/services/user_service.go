@@ -1,11 +1,36 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/repositories"
 )
 
+// Profile limits, lengths are counted in characters
+const (
+	MaxBioLength          = 190
+	MaxPronounsLength     = 40
+	MaxCustomStatusLength = 128
+	MaxStatusEmojiLength  = 64
+	MaxNicknameLength     = 32
+	MaxProfileImageSize   = 8 << 20
+)
+
+var (
+	ErrBioTooLong          = fmt.Errorf("bio must be at most %d characters", MaxBioLength)
+	ErrPronounsTooLong     = fmt.Errorf("pronouns must be at most %d characters", MaxPronounsLength)
+	ErrCustomStatusTooLong = fmt.Errorf("custom status must be at most %d characters", MaxCustomStatusLength)
+	ErrStatusEmojiTooLong  = fmt.Errorf("custom status emoji must be at most %d characters", MaxStatusEmojiLength)
+	ErrStatusExpired       = errors.New("custom status expiry must be in the future")
+	ErrNicknameTooLong     = fmt.Errorf("nickname must be at most %d characters", MaxNicknameLength)
+)
+
 type UserService struct {
 	DB *gorm.DB
 }
@@ -38,3 +63,86 @@ func (service *UserService) DeleteUser(user *entity.User) error {
 	userRepository := repositories.NewUserRepository(service.DB)
 	return userRepository.DeleteUser(user)
 }
+
+// UpdateProfile changes the bio and pronouns of the user, nil fields are left unchanged
+func (service *UserService) UpdateProfile(userID uint, bio *string, pronouns *string) (*entity.User, error) {
+	return service.updateProfile(userID, func(user *entity.User) error {
+		if bio != nil {
+			if utf8.RuneCountInString(strings.TrimSpace(*bio)) > MaxBioLength {
+				return ErrBioTooLong
+			}
+			user.Bio = strings.TrimSpace(*bio)
+		}
+		if pronouns != nil {
+			if utf8.RuneCountInString(strings.TrimSpace(*pronouns)) > MaxPronounsLength {
+				return ErrPronounsTooLong
+			}
+			user.Pronouns = strings.TrimSpace(*pronouns)
+		}
+		return nil
+	})
+}
+
+// SetAvatar replaces the avatar of the user with an uploaded image
+func (service *UserService) SetAvatar(userID uint, path string) (*entity.User, error) {
+	return service.updateProfile(userID, func(user *entity.User) error {
+		user.Avatar = path
+		return nil
+	})
+}
+
+// SetBanner replaces the banner of the user with an uploaded image
+func (service *UserService) SetBanner(userID uint, path string) (*entity.User, error) {
+	return service.updateProfile(userID, func(user *entity.User) error {
+		user.Banner = path
+		return nil
+	})
+}
+
+// SetCustomStatus sets the custom status of the user until expiresAt, nil keeps it until it is cleared.
+// An empty text and emoji clears it.
+func (service *UserService) SetCustomStatus(userID uint, text string, emoji string, expiresAt *time.Time) (*entity.User, error) {
+	text = strings.TrimSpace(text)
+	emoji = strings.TrimSpace(emoji)
+	if utf8.RuneCountInString(text) > MaxCustomStatusLength {
+		return nil, ErrCustomStatusTooLong
+	}
+	if utf8.RuneCountInString(emoji) > MaxStatusEmojiLength {
+		return nil, ErrStatusEmojiTooLong
+	}
+	if text == "" && emoji == "" {
+		expiresAt = nil
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, ErrStatusExpired
+	}
+
+	return service.updateProfile(userID, func(user *entity.User) error {
+		user.CustomStatusText = text
+		user.CustomStatusEmoji = emoji
+		user.CustomStatusExpiresAt = expiresAt
+		return nil
+	})
+}
+
+// GetSharedServerUserIDs returns the members of the servers the user belongs to
+func (service *UserService) GetSharedServerUserIDs(userID uint) ([]uint, error) {
+	userRepository := repositories.NewUserRepository(service.DB)
+	return userRepository.GetSharedServerUserIDs(userID)
+}
+
+// updateProfile applies a change to the profile of the user and saves it
+func (service *UserService) updateProfile(userID uint, update func(user *entity.User) error) (*entity.User, error) {
+	userRepository := repositories.NewUserRepository(service.DB)
+	user, err := userRepository.GetUserById(fmt.Sprintf("%d", userID))
+	if err != nil {
+		return nil, err
+	}
+	if err := update(user); err != nil {
+		return nil, err
+	}
+	if err := userRepository.UpdateProfile(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
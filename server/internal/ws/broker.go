@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// PresenceTTL is how long the connections an instance shares for a user last without being renewed
+const PresenceTTL = time.Minute
+
+// Targets of a BrokerEvent
+const (
+	TargetUser    = "user"
+	TargetServer  = "server"
+	TargetChannel = "channel"
+	TargetThread  = "thread"
+)
+
+// BrokerEvent is an event for the sessions subscribed to a user, server, channel or thread,
+// whichever server instance they are connected to
+type BrokerEvent struct {
+	Target       string              `json:"target"`
+	ID           uint                `json:"id"`
+	ExceptUserID uint                `json:"except_user_id,omitempty"` // Sessions of this user are skipped
+	Subscription *SubscriptionChange `json:"subscription,omitempty"`   // Applied to the sessions before the message is sent
+	Message      json.RawMessage     `json:"message,omitempty"`
+}
+
+// SubscriptionChange subscribes sessions to servers and channels, or unsubscribes them when Leave is set
+type SubscriptionChange struct {
+	Servers  []uint `json:"servers,omitempty"`
+	Channels []uint `json:"channels,omitempty"`
+	Leave    bool   `json:"leave,omitempty"`
+}
+
+// Broker carries events between the hubs of the server instances. Every event published
+// by an instance is handed to the subscribers of every instance, including itself.
+type Broker interface {
+	Publish(event BrokerEvent) error
+	Subscribe(handler func(event BrokerEvent)) error
+	Close() error
+}
+
+// PresenceDirectory is implemented by the brokers shared by several instances, so that the
+// presence of a user is derived from their connections to every instance
+type PresenceDirectory interface {
+	// SetStatus shares the status chosen by a user
+	SetStatus(userID uint, status string) error
+	// SetConnections shares for PresenceTTL whether the user is connected to this instance
+	// and whether all their connections to it are idle
+	SetConnections(userID uint, connected bool, idle bool) error
+	// GetPresences returns the shared presence of the users connected to any instance
+	GetPresences(userIDs []uint) (map[uint]SharedPresence, error)
+	// SwapPresence records the presence last broadcast for a user and returns the previous one
+	SwapPresence(userID uint, presence string) (string, error)
+}
+
+// SharedPresence is the status of a connected user and whether all their connections are idle
+type SharedPresence struct {
+	Status string
+	Idle   bool
+}
+
+// MemoryBroker hands the events to the subscribers of the same process, it is used
+// when the server runs as a single instance
+type MemoryBroker struct {
+	mutex    sync.RWMutex
+	handlers []func(event BrokerEvent)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (broker *MemoryBroker) Publish(event BrokerEvent) error {
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+
+	for _, handler := range broker.handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (broker *MemoryBroker) Subscribe(handler func(event BrokerEvent)) error {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.handlers = append(broker.handlers, handler)
+	return nil
+}
+
+func (broker *MemoryBroker) Close() error {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.handlers = nil
+	return nil
+}
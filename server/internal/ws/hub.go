@@ -0,0 +1,295 @@
+package ws
+
+import (
+	"log"
+	"sync"
+)
+
+// Hub tracks the sessions of each user and the servers, channels and threads they subscribed to.
+// Connections read and change it from their own goroutines, so its maps and the subscriptions
+// kept on each client are only accessed under its lock. Events go through its broker so that
+// they reach the sessions connected to the other server instances.
+type Hub struct {
+	mutex    sync.RWMutex
+	users    map[uint]map[*Client]bool
+	servers  map[uint]map[*Client]bool
+	channels map[uint]map[*Client]bool
+	threads  map[uint]map[*Client]bool
+	sessions map[string]*Client
+	broker   Broker
+}
+
+func NewHub(broker Broker) *Hub {
+	h := &Hub{
+		users:    make(map[uint]map[*Client]bool),
+		servers:  make(map[uint]map[*Client]bool),
+		channels: make(map[uint]map[*Client]bool),
+		threads:  make(map[uint]map[*Client]bool),
+		sessions: make(map[string]*Client),
+		broker:   broker,
+	}
+	broker.Subscribe(h.deliver)
+	return h
+}
+
+var hub = NewHub(NewMemoryBroker())
+
+// SetBroker replaces the broker of the hub, before any connection is accepted
+func SetBroker(broker Broker) error {
+	if err := broker.Subscribe(hub.deliver); err != nil {
+		return err
+	}
+	hub.broker.Close()
+	hub.broker = broker
+	if directory, ok := broker.(PresenceDirectory); ok {
+		go renewPresences(directory)
+	}
+	return nil
+}
+
+// Register adds a new connection of a user
+func (h *Hub) Register(client *Client) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	subscribe(h.users, client.UserID, client)
+	h.sessions[client.SessionID] = client
+}
+
+// Unregister removes a session from the hub along with its subscriptions, and closes
+// its send channel once nothing can send to it anymore. It reports whether it removed
+// the last session of the user.
+func (h *Hub) Unregister(client *Client) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.users[client.UserID][client] {
+		return false
+	}
+	h.remove(client)
+	if client.Send != nil {
+		close(client.Send)
+		client.Send = nil
+	}
+	return len(h.users[client.UserID]) == 0
+}
+
+// remove drops a session and its subscriptions, the hub lock must be held
+func (h *Hub) remove(client *Client) {
+	unsubscribe(h.users, client.UserID, client)
+	for serverID := range client.Servers {
+		unsubscribe(h.servers, serverID, client)
+	}
+	for channelID := range client.Channels {
+		unsubscribe(h.channels, channelID, client)
+	}
+	for threadID := range client.Threads {
+		unsubscribe(h.threads, threadID, client)
+	}
+	if h.sessions[client.SessionID] == client {
+		delete(h.sessions, client.SessionID)
+	}
+}
+
+// JoinServer subscribes a connection to the events of a server and of its channels
+func (h *Hub) JoinServer(client *Client, serverID uint, channelIDs []uint) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.users[client.UserID][client] {
+		return
+	}
+	h.applySubscription(client, SubscriptionChange{Servers: []uint{serverID}, Channels: channelIDs})
+}
+
+// JoinChannel subscribes a connection to the messages of a channel
+func (h *Hub) JoinChannel(client *Client, channelID uint) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.users[client.UserID][client] {
+		return
+	}
+	subscribe(h.channels, channelID, client)
+	client.Channels[channelID] = true
+}
+
+// LeaveChannel unsubscribes a connection from a channel
+func (h *Hub) LeaveChannel(client *Client, channelID uint) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	unsubscribe(h.channels, channelID, client)
+	delete(client.Channels, channelID)
+	delete(client.DirectChannels, channelID)
+}
+
+// UpdateUserSubscriptions subscribes or unsubscribes every session of a user, on every
+// server instance, then pushes the message to them when there is one
+func (h *Hub) UpdateUserSubscriptions(userID uint, change SubscriptionChange, message []byte) {
+	h.publish(BrokerEvent{Target: TargetUser, ID: userID, Subscription: &change, Message: message})
+}
+
+// CloseChannel pushes a last message to the subscribers of a channel and unsubscribes them
+func (h *Hub) CloseChannel(channelID uint, message []byte) {
+	change := SubscriptionChange{Channels: []uint{channelID}, Leave: true}
+	h.publish(BrokerEvent{Target: TargetChannel, ID: channelID, Subscription: &change, Message: message})
+}
+
+// CloseServer pushes a last message to the subscribers of a server and unsubscribes them
+// from the server and its channels
+func (h *Hub) CloseServer(serverID uint, channelIDs []uint, message []byte) {
+	change := SubscriptionChange{Servers: []uint{serverID}, Channels: channelIDs, Leave: true}
+	h.publish(BrokerEvent{Target: TargetServer, ID: serverID, Subscription: &change, Message: message})
+}
+
+// applySubscription changes the subscriptions of a session, the hub lock must be held
+func (h *Hub) applySubscription(client *Client, change SubscriptionChange) {
+	for _, serverID := range change.Servers {
+		if change.Leave {
+			unsubscribe(h.servers, serverID, client)
+			delete(client.Servers, serverID)
+		} else {
+			subscribe(h.servers, serverID, client)
+			client.Servers[serverID] = true
+		}
+	}
+	for _, channelID := range change.Channels {
+		if change.Leave {
+			unsubscribe(h.channels, channelID, client)
+			delete(client.Channels, channelID)
+			delete(client.DirectChannels, channelID)
+		} else {
+			subscribe(h.channels, channelID, client)
+			client.Channels[channelID] = true
+		}
+	}
+}
+
+// IsSubscribed reports whether a connection joined a channel
+func (h *Hub) IsSubscribed(client *Client, channelID uint) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return client.Channels[channelID]
+}
+
+// JoinThread subscribes a connection to the messages of a thread
+func (h *Hub) JoinThread(client *Client, threadID uint) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.users[client.UserID][client] {
+		return
+	}
+	subscribe(h.threads, threadID, client)
+	client.Threads[threadID] = true
+}
+
+// SendToUser pushes a message to every connection of the user
+func (h *Hub) SendToUser(userID uint, message []byte) {
+	h.publish(BrokerEvent{Target: TargetUser, ID: userID, Message: message})
+}
+
+// pushTo pushes a message to one session
+func (h *Hub) pushTo(client *Client, message []byte) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	client.push(message)
+}
+
+// BroadcastToChannel pushes a message to the connections subscribed to a channel
+func (h *Hub) BroadcastToChannel(channelID uint, message []byte) {
+	h.BroadcastToChannelExcept(channelID, 0, message)
+}
+
+// BroadcastToChannelExcept pushes a message to the connections subscribed to a channel,
+// except those of a user
+func (h *Hub) BroadcastToChannelExcept(channelID uint, userID uint, message []byte) {
+	h.publish(BrokerEvent{Target: TargetChannel, ID: channelID, ExceptUserID: userID, Message: message})
+}
+
+// BroadcastToServer pushes a message to the connections subscribed to a server
+func (h *Hub) BroadcastToServer(serverID uint, message []byte) {
+	h.publish(BrokerEvent{Target: TargetServer, ID: serverID, Message: message})
+}
+
+// BroadcastToThread pushes a message to the connections subscribed to a thread
+func (h *Hub) BroadcastToThread(threadID uint, message []byte) {
+	h.publish(BrokerEvent{Target: TargetThread, ID: threadID, Message: message})
+}
+
+func (h *Hub) publish(event BrokerEvent) {
+	if err := h.broker.Publish(event); err != nil {
+		log.Println("Failed to publish event:", err)
+	}
+}
+
+// deliver pushes an event of the broker to the sessions connected to this instance,
+// after changing their subscriptions when the event carries a SubscriptionChange
+func (h *Hub) deliver(event BrokerEvent) {
+	if event.Subscription == nil {
+		h.mutex.RLock()
+		defer h.mutex.RUnlock()
+
+		send(h.targets(event), event.Message, event.ExceptUserID)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// The subscriptions change while going through the sessions
+	clients := make(map[*Client]bool)
+	for client := range h.targets(event) {
+		clients[client] = true
+	}
+	for client := range clients {
+		h.applySubscription(client, *event.Subscription)
+	}
+	send(clients, event.Message, event.ExceptUserID)
+}
+
+// targets returns the sessions an event is for, the hub lock must be held
+func (h *Hub) targets(event BrokerEvent) map[*Client]bool {
+	switch event.Target {
+	case TargetUser:
+		return h.users[event.ID]
+	case TargetServer:
+		return h.servers[event.ID]
+	case TargetChannel:
+		return h.channels[event.ID]
+	case TargetThread:
+		return h.threads[event.ID]
+	}
+	log.Println("Unknown broker event target:", event.Target)
+	return nil
+}
+
+func subscribe(subscriptions map[uint]map[*Client]bool, id uint, client *Client) {
+	if _, ok := subscriptions[id]; !ok {
+		subscriptions[id] = make(map[*Client]bool)
+	}
+	subscriptions[id][client] = true
+}
+
+func unsubscribe(subscriptions map[uint]map[*Client]bool, id uint, client *Client) {
+	delete(subscriptions[id], client)
+	if len(subscriptions[id]) == 0 {
+		delete(subscriptions, id)
+	}
+}
+
+// send pushes a message to the sessions without blocking, skipping the sessions of excludedUserID
+func send(clients map[*Client]bool, message []byte, excludedUserID uint) {
+	if len(message) == 0 {
+		return
+	}
+	for client := range clients {
+		if excludedUserID != 0 && client.UserID == excludedUserID {
+			continue
+		}
+		client.push(message)
+	}
+}
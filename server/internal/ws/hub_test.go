@@ -0,0 +1,230 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"lesha.com/server/pkg/gateway"
+)
+
+func newTestClient(userID uint) *Client {
+	return &Client{
+		Send:      make(chan []byte, 256),
+		UserID:    userID,
+		SessionID: newSessionID(),
+		Version:   gateway.Version1,
+		Servers:   make(map[uint]bool),
+		Channels:  make(map[uint]bool),
+		Threads:   make(map[uint]bool),
+
+		DirectChannels: make(map[uint][]uint),
+	}
+}
+
+// receive returns the next frame queued for a client, or fails the test
+func receive(t *testing.T, client *Client) string {
+	t.Helper()
+
+	select {
+	case message := <-client.Send:
+		return string(message)
+	case <-time.After(time.Second):
+		t.Fatalf("no message received by user %d", client.UserID)
+		return ""
+	}
+}
+
+// expectNothing fails the test when a frame is queued for a client
+func expectNothing(t *testing.T, client *Client) {
+	t.Helper()
+
+	select {
+	case message := <-client.Send:
+		t.Fatalf("user %d received unexpected message %s", client.UserID, message)
+	default:
+	}
+}
+
+func TestHubBroadcasts(t *testing.T) {
+	hub := NewHub(NewMemoryBroker())
+	alice := newTestClient(1)
+	bob := newTestClient(2)
+	outsider := newTestClient(3)
+	for _, client := range []*Client{alice, bob, outsider} {
+		hub.Register(client)
+	}
+	hub.JoinServer(alice, 100, []uint{10})
+	hub.JoinServer(bob, 100, []uint{10})
+	hub.JoinThread(bob, 50)
+
+	hub.BroadcastToChannel(10, []byte(`{"type":"A"}`))
+	if got := receive(t, alice); got != `{"seq":1,"type":"A"}` {
+		t.Errorf("alice received %s", got)
+	}
+	if got := receive(t, bob); got != `{"seq":1,"type":"A"}` {
+		t.Errorf("bob received %s", got)
+	}
+	expectNothing(t, outsider)
+
+	hub.BroadcastToChannelExcept(10, alice.UserID, []byte(`{"type":"B"}`))
+	receive(t, bob)
+	expectNothing(t, alice)
+
+	hub.BroadcastToThread(50, []byte(`{"type":"C"}`))
+	receive(t, bob)
+	expectNothing(t, alice)
+
+	hub.SendToUser(outsider.UserID, []byte(`{"type":"D"}`))
+	receive(t, outsider)
+	expectNothing(t, alice)
+
+	hub.LeaveChannel(alice, 10)
+	hub.BroadcastToChannel(10, []byte(`{"type":"E"}`))
+	receive(t, bob)
+	expectNothing(t, alice)
+
+	hub.BroadcastToServer(100, []byte(`{"type":"F"}`))
+	receive(t, alice)
+	receive(t, bob)
+	expectNothing(t, outsider)
+}
+
+func TestHubSubscriptionChanges(t *testing.T) {
+	hub := NewHub(NewMemoryBroker())
+	first := newTestClient(1)
+	second := newTestClient(1)
+	other := newTestClient(2)
+	for _, client := range []*Client{first, second, other} {
+		hub.Register(client)
+	}
+	hub.JoinServer(other, 100, []uint{10})
+
+	hub.UpdateUserSubscriptions(1, SubscriptionChange{Servers: []uint{100}, Channels: []uint{10, 11}}, []byte(`{"type":"SERVER_ADD"}`))
+	receive(t, first)
+	receive(t, second)
+	for _, client := range []*Client{first, second} {
+		if !hub.IsSubscribed(client, 10) || !hub.IsSubscribed(client, 11) {
+			t.Errorf("session %s was not subscribed to the channels of the server", client.SessionID)
+		}
+	}
+
+	hub.CloseChannel(11, []byte(`{"type":"CHANNEL_DELETE"}`))
+	receive(t, first)
+	receive(t, second)
+	if hub.IsSubscribed(first, 11) || hub.IsSubscribed(second, 11) {
+		t.Errorf("sessions are still subscribed to a closed channel")
+	}
+
+	hub.CloseServer(100, []uint{10}, []byte(`{"type":"SERVER_DELETE"}`))
+	for _, client := range []*Client{first, second, other} {
+		receive(t, client)
+		if hub.IsSubscribed(client, 10) {
+			t.Errorf("session %s is still subscribed to a channel of a closed server", client.SessionID)
+		}
+	}
+	hub.BroadcastToServer(100, []byte(`{"type":"A"}`))
+	for _, client := range []*Client{first, second, other} {
+		expectNothing(t, client)
+	}
+}
+
+func TestHubUnregister(t *testing.T) {
+	hub := NewHub(NewMemoryBroker())
+	first := newTestClient(1)
+	second := newTestClient(1)
+	hub.Register(first)
+	hub.Register(second)
+	hub.JoinChannel(first, 10)
+
+	send := first.Send
+	if hub.Unregister(first) {
+		t.Errorf("Unregister reported the last session while another one is registered")
+	}
+	if _, ok := <-send; ok {
+		t.Errorf("the send channel of an unregistered session is still open")
+	}
+	if hub.Unregister(first) {
+		t.Errorf("Unregister reported the last session for a session that was already removed")
+	}
+
+	// A removed session cannot subscribe again
+	hub.JoinChannel(first, 20)
+	if hub.IsSubscribed(first, 20) {
+		t.Errorf("an unregistered session was subscribed to a channel")
+	}
+
+	if !hub.Unregister(second) {
+		t.Errorf("Unregister did not report the last session of the user")
+	}
+	assertHubEmpty(t, hub)
+}
+
+// TestHubConcurrentSessions registers, subscribes, broadcasts and unregisters from many
+// goroutines at once, it is meant to be run with the race detector
+func TestHubConcurrentSessions(t *testing.T) {
+	const (
+		users    = 20
+		sessions = 5
+		channels = 4
+		rounds   = 50
+	)
+	hub := NewHub(NewMemoryBroker())
+
+	var wg sync.WaitGroup
+	for userID := uint(1); userID <= users; userID++ {
+		for session := 0; session < sessions; session++ {
+			wg.Add(1)
+			go func(userID uint) {
+				defer wg.Done()
+
+				client := newTestClient(userID)
+				send := client.Send
+				drained := make(chan struct{})
+				go func() {
+					for range send {
+					}
+					close(drained)
+				}()
+
+				hub.Register(client)
+				hub.JoinServer(client, 1, nil)
+				for round := 0; round < rounds; round++ {
+					channelID := uint(round%channels + 1)
+					message := []byte(fmt.Sprintf(`{"type":"MESSAGE","user":%d,"round":%d}`, userID, round))
+
+					hub.JoinChannel(client, channelID)
+					hub.JoinThread(client, channelID)
+					hub.BroadcastToChannel(channelID, message)
+					hub.BroadcastToChannelExcept(channelID, userID, message)
+					hub.BroadcastToThread(channelID, message)
+					hub.BroadcastToServer(1, message)
+					hub.SendToUser(userID, message)
+					hub.IsSubscribed(client, channelID)
+					if round%3 == 0 {
+						hub.UpdateUserSubscriptions(userID, SubscriptionChange{Channels: []uint{channelID}, Leave: true}, message)
+					}
+					hub.LeaveChannel(client, channelID)
+				}
+				hub.Unregister(client)
+				<-drained
+			}(userID)
+		}
+	}
+	wg.Wait()
+
+	assertHubEmpty(t, hub)
+}
+
+func assertHubEmpty(t *testing.T, hub *Hub) {
+	t.Helper()
+
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	if len(hub.users) != 0 || len(hub.servers) != 0 || len(hub.channels) != 0 || len(hub.threads) != 0 || len(hub.sessions) != 0 {
+		t.Errorf("hub still tracks %d users, %d servers, %d channels, %d threads and %d sessions",
+			len(hub.users), len(hub.servers), len(hub.channels), len(hub.threads), len(hub.sessions))
+	}
+}
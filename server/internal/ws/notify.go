@@ -0,0 +1,146 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+)
+
+// SendToUser pushes a message to every connection of the user
+func SendToUser(userID uint, message []byte) {
+	hub.SendToUser(userID, message)
+}
+
+// RemoveFromServer unsubscribes the connections of the user from a server and its
+// channels and tells their clients to drop it. The reason is "kick", "ban" or "leave".
+func RemoveFromServer(userID uint, serverID uint, channelIDs []uint, reason string) {
+	payload, _ := json.Marshal(struct {
+		Type     string `json:"type"`
+		ServerID uint   `json:"server_id"`
+		Reason   string `json:"reason"`
+	}{
+		Type:     "SERVER_REMOVE",
+		ServerID: serverID,
+		Reason:   reason,
+	})
+	change := SubscriptionChange{Servers: []uint{serverID}, Channels: channelIDs, Leave: true}
+	hub.UpdateUserSubscriptions(userID, change, payload)
+}
+
+// NotifyTimeout tells the user they cannot send messages in a server until the given time
+func NotifyTimeout(userID uint, serverID uint, until *time.Time) {
+	payload, _ := json.Marshal(struct {
+		Type     string     `json:"type"`
+		ServerID uint       `json:"server_id"`
+		Until    *time.Time `json:"until"`
+	}{
+		Type:     "MEMBER_TIMEOUT",
+		ServerID: serverID,
+		Until:    until,
+	})
+	SendToUser(userID, payload)
+}
+
+// NotifyMentions pushes a MENTION event to every user notified by a message,
+// whether or not they joined its channel
+func NotifyMentions(message *entity.Message, recipients []entity.UserMention) {
+	if len(recipients) == 0 {
+		return
+	}
+
+	payload, _ := json.Marshal(struct {
+		Type      string                 `json:"type"`
+		ChannelID uint                   `json:"channel_id"`
+		ThreadID  *uint                  `json:"thread_id"`
+		Message   entity.MessageResponse `json:"message"`
+	}{
+		Type:      "MENTION",
+		ChannelID: message.ChannelID,
+		ThreadID:  message.ThreadID,
+		Message:   message.ToResponse(),
+	})
+	for _, recipient := range recipients {
+		SendToUser(recipient.UserID, payload)
+	}
+}
+
+// NotifyReadState tells every connection of the user that a channel was read up to a message
+func NotifyReadState(userID uint, channelID uint, messageID uint) {
+	payload, _ := json.Marshal(struct {
+		Type      string `json:"type"`
+		ChannelID uint   `json:"channel_id"`
+		MessageID uint   `json:"message_id"`
+	}{
+		Type:      "READ_STATE_UPDATE",
+		ChannelID: channelID,
+		MessageID: messageID,
+	})
+	SendToUser(userID, payload)
+}
+
+// BroadcastUserUpdate sends the public fields of a user to all their connections
+// and to the members of the servers they belong to
+func BroadcastUserUpdate(db *gorm.DB, user *entity.User) {
+	userService := services.NewUserService(db)
+	userIDs, err := userService.GetSharedServerUserIDs(user.ID)
+	if err != nil {
+		log.Println("Failed to fetch server members:", err)
+		return
+	}
+
+	notifyUserUpdate(append(userIDs, user.ID), 0, user.ToResponse())
+}
+
+// BroadcastMemberUpdate sends the new nickname of a member to the members of the server
+func BroadcastMemberUpdate(db *gorm.DB, serverID uint, user *entity.User, nickname string) {
+	serverService := services.NewServerService(db)
+	userIDs, err := serverService.GetServerMemberIDs(serverID)
+	if err != nil {
+		log.Println("Failed to fetch server members:", err)
+		return
+	}
+
+	response := user.ToResponse()
+	response.Nickname = nickname
+	notifyUserUpdate(userIDs, serverID, response)
+}
+
+// notifyUserUpdate sends a USER_UPDATE event to the users, about a server when serverID is set
+func notifyUserUpdate(userIDs []uint, serverID uint, user entity.UserResponse) {
+	payload, _ := json.Marshal(struct {
+		Type     string              `json:"type"`
+		ServerID uint                `json:"server_id,omitempty"`
+		User     entity.UserResponse `json:"user"`
+	}{
+		Type:     "USER_UPDATE",
+		ServerID: serverID,
+		User:     user,
+	})
+	for _, userID := range userIDs {
+		SendToUser(userID, payload)
+	}
+}
+
+// NotifyFriendship sends a FRIEND_REQUEST or FRIEND_UPDATE event to both users of a
+// relationship, each seeing the other user
+func NotifyFriendship(eventType string, friendship *entity.Friendship) {
+	NotifyRelationship(friendship.UserID, eventType, friendship)
+	NotifyRelationship(friendship.FriendID, eventType, friendship)
+}
+
+// NotifyRelationship sends a FRIEND_REQUEST or FRIEND_UPDATE event to one user of a relationship,
+// blocks are only sent to the blocker
+func NotifyRelationship(userID uint, eventType string, friendship *entity.Friendship) {
+	payload, _ := json.Marshal(struct {
+		Type         string                    `json:"type"`
+		Relationship entity.FriendshipResponse `json:"relationship"`
+	}{
+		Type:         eventType,
+		Relationship: friendship.ToResponse(userID),
+	})
+	SendToUser(userID, payload)
+}
@@ -0,0 +1,272 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+)
+
+// userStatuses holds the status chosen by each connected user, guarded by the hub lock
+var userStatuses = make(map[uint]string)
+
+// presences holds the last presence broadcast for each user still seen online, guarded by the hub lock.
+// Instances sharing their presence keep it in the broker instead.
+var presences = make(map[uint]string)
+
+// presenceMutex orders the presence updates so that they are broadcast in sequence
+var presenceMutex sync.Mutex
+
+// GetPresence returns the presence of a user as seen by other users
+func GetPresence(userID uint) string {
+	if presence, ok := GetPresences([]uint{userID})[userID]; ok {
+		return presence
+	}
+	return entity.PresenceOffline
+}
+
+// GetPresences returns the presence of the users who are not offline. When the broker shares
+// presence, the connections of the users to every instance are taken into account.
+func GetPresences(userIDs []uint) map[uint]string {
+	result := make(map[uint]string)
+	if directory, ok := presenceDirectory(); ok {
+		shared, err := directory.GetPresences(userIDs)
+		if err == nil {
+			for userID, state := range shared {
+				if presence := state.presence(); presence != entity.PresenceOffline {
+					result[userID] = presence
+				}
+			}
+			return result
+		}
+		log.Println("Failed to fetch shared presences:", err)
+	}
+
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	for _, userID := range userIDs {
+		if presence := presenceOf(userID); presence != entity.PresenceOffline {
+			result[userID] = presence
+		}
+	}
+	return result
+}
+
+// presenceDirectory returns the broker when it shares presence between instances
+func presenceDirectory() (PresenceDirectory, bool) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	directory, ok := hub.broker.(PresenceDirectory)
+	return directory, ok
+}
+
+func (state SharedPresence) presence() string {
+	return presenceFrom(state.Status, state.Idle)
+}
+
+// presenceFrom derives the presence of a connected user from their status and whether all
+// their connections are idle. Invisible users appear offline, and online users are idle
+// when all their connections are.
+func presenceFrom(status string, idle bool) string {
+	switch status {
+	case entity.PresenceInvisible:
+		return entity.PresenceOffline
+	case entity.PresenceIdle, entity.PresenceDND:
+		return status
+	}
+	if idle {
+		return entity.PresenceIdle
+	}
+	return entity.PresenceOnline
+}
+
+// presenceOf derives the presence of a user from their connections to this instance,
+// the hub lock must be held
+func presenceOf(userID uint) string {
+	if !isConnected(userID) {
+		return entity.PresenceOffline
+	}
+	return presenceFrom(userStatuses[userID], isIdle(userID))
+}
+
+// isConnected reports whether the user has an open connection, closed sessions waiting to be
+// resumed do not count. The hub lock must be held.
+func isConnected(userID uint) bool {
+	for client := range hub.users[userID] {
+		if client.Send != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdle reports whether every open connection of the user is idle, the hub lock must be held
+func isIdle(userID uint) bool {
+	for client := range hub.users[userID] {
+		if client.Send != nil && !client.Idle {
+			return false
+		}
+	}
+	return true
+}
+
+func setUserStatus(userID uint, status string) {
+	hub.mutex.Lock()
+	userStatuses[userID] = status
+	hub.mutex.Unlock()
+
+	if directory, ok := presenceDirectory(); ok {
+		if err := directory.SetStatus(userID, status); err != nil {
+			log.Println("Failed to share status:", err)
+		}
+	}
+}
+
+func (c *Client) setIdle(idle bool) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	c.Idle = idle
+}
+
+// updatePresence sends a PRESENCE_UPDATE event to the friends of the user and the members
+// of their servers when their presence changed. The last seen time is saved when the user
+// closed their last connection.
+func updatePresence(db *gorm.DB, userID uint) {
+	presenceMutex.Lock()
+	defer presenceMutex.Unlock()
+
+	hub.mutex.Lock()
+	presence := presenceOf(userID)
+	connected := isConnected(userID)
+	idle := isIdle(userID)
+	if !connected {
+		delete(userStatuses, userID)
+	}
+	hub.mutex.Unlock()
+
+	shared := false
+	var previous string
+	if directory, ok := presenceDirectory(); ok {
+		sharedPresence, sharedConnected, sharedPrevious, err := sharePresence(directory, userID, connected, idle)
+		if err != nil {
+			log.Println("Failed to share presence:", err)
+		} else {
+			shared = true
+			presence, connected, previous = sharedPresence, sharedConnected, sharedPrevious
+		}
+	}
+	if !shared {
+		previous = swapPresence(userID, presence)
+	}
+
+	presenceService := services.NewPresenceService(db)
+	response := entity.PresenceResponse{UserID: userID, Status: presence}
+	if !connected {
+		lastSeenAt := time.Now()
+		if err := presenceService.UpdateLastSeen(userID, lastSeenAt); err != nil {
+			log.Println("Failed to save last seen time:", err)
+		}
+		response.LastSeenAt = &lastSeenAt
+	}
+	if presence == previous {
+		return
+	}
+
+	audience, err := presenceService.GetPresenceAudience(userID)
+	if err != nil {
+		log.Println("Failed to fetch presence audience:", err)
+		return
+	}
+
+	payload, _ := json.Marshal(struct {
+		Type     string                  `json:"type"`
+		Presence entity.PresenceResponse `json:"presence"`
+	}{
+		Type:     "PRESENCE_UPDATE",
+		Presence: response,
+	})
+	for _, audienceID := range audience {
+		SendToUser(audienceID, payload)
+	}
+}
+
+// swapPresence records the presence last broadcast for a user of this instance and returns
+// the previous one
+func swapPresence(userID uint, presence string) string {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	previous, ok := presences[userID]
+	if !ok {
+		previous = entity.PresenceOffline
+	}
+	if presence == entity.PresenceOffline {
+		delete(presences, userID)
+	} else {
+		presences[userID] = presence
+	}
+	return previous
+}
+
+// sharePresence shares the connections of the user to this instance, then returns their
+// presence across every instance, whether they are connected to any of them, and the
+// presence last broadcast by any instance
+func sharePresence(directory PresenceDirectory, userID uint, connected bool, idle bool) (string, bool, string, error) {
+	if err := directory.SetConnections(userID, connected, idle); err != nil {
+		return "", false, "", err
+	}
+	shared, err := directory.GetPresences([]uint{userID})
+	if err != nil {
+		return "", false, "", err
+	}
+
+	presence := entity.PresenceOffline
+	state, connected := shared[userID]
+	if connected {
+		presence = state.presence()
+	}
+	previous, err := directory.SwapPresence(userID, presence)
+	if err != nil {
+		return "", false, "", err
+	}
+	return presence, connected, previous, nil
+}
+
+// renewPresences keeps sharing the connections of the users of this instance before they expire
+func renewPresences(directory PresenceDirectory) {
+	for range time.Tick(PresenceTTL / 3) {
+		hub.mutex.RLock()
+		idle := make(map[uint]bool, len(hub.users))
+		for userID := range hub.users {
+			if isConnected(userID) {
+				idle[userID] = isIdle(userID)
+			}
+		}
+		hub.mutex.RUnlock()
+
+		for userID, allIdle := range idle {
+			if err := directory.SetConnections(userID, true, allIdle); err != nil {
+				log.Println("Failed to renew shared presence:", err)
+			}
+		}
+	}
+}
+
+// NotifyStatus tells every connection of the user the status they chose
+func NotifyStatus(userID uint, status string) {
+	payload, _ := json.Marshal(struct {
+		Type     string                  `json:"type"`
+		Presence entity.PresenceResponse `json:"presence"`
+	}{
+		Type:     "PRESENCE_UPDATE",
+		Presence: entity.PresenceResponse{UserID: userID, Status: status},
+	})
+	SendToUser(userID, payload)
+}
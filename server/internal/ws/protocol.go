@@ -0,0 +1,145 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+	"lesha.com/server/pkg/gateway"
+)
+
+// command is a frame sent by the client, whichever the protocol version
+type command struct {
+	Type  string
+	Nonce string
+	Data  json.RawMessage
+}
+
+// decode reads the data of the command into one of the gateway command types
+func (cmd *command) decode(data interface{}) error {
+	if len(cmd.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(cmd.Data, data); err != nil {
+		return commandError(gateway.ErrorInvalidData, "Invalid %s data", cmd.Type)
+	}
+	return nil
+}
+
+// parseCommand reads a frame of the client. Version 1 frames are flat objects whose
+// fields are the data of the command, later versions are wrapped in an Envelope.
+func (c *Client) parseCommand(raw []byte) (*command, error) {
+	if c.Version == gateway.Version1 {
+		var frame struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			return nil, commandError(gateway.ErrorInvalidFrame, "Invalid frame")
+		}
+		return &command{Type: frame.Type, Data: raw}, nil
+	}
+
+	var envelope gateway.Envelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, commandError(gateway.ErrorInvalidFrame, "Invalid frame")
+	}
+	cmd := &command{Type: envelope.Type, Nonce: envelope.Nonce, Data: envelope.Data}
+	if envelope.Op != gateway.OpCommand {
+		return cmd, commandError(gateway.ErrorInvalidFrame, "Clients can only send command frames")
+	}
+	return cmd, nil
+}
+
+func commandError(code gateway.ErrorCode, format string, args ...interface{}) *gateway.Error {
+	return &gateway.Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// lookupError reports a missing record as NOT_FOUND, other errors are internal
+func lookupError(err error, format string, args ...interface{}) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return commandError(gateway.ErrorNotFound, format, args...)
+	}
+	return err
+}
+
+// permissionError turns the result of a permission check into an error, FORBIDDEN when it was denied
+func permissionError(allowed bool, err error, format string, args ...interface{}) error {
+	if err != nil {
+		return lookupError(err, format, args...)
+	}
+	if !allowed {
+		return commandError(gateway.ErrorForbidden, format, args...)
+	}
+	return nil
+}
+
+// replyTo answers a command with an ACK frame, or an ERROR frame when it failed.
+// Version 1 clients get no answer, failures are only logged.
+func (c *Client) replyTo(cmd *command, ack *gateway.Ack, err error) {
+	if err != nil {
+		var commandErr *gateway.Error
+		if !errors.As(err, &commandErr) {
+			log.Printf("User %d %s failed: %v", c.UserID, cmd.Type, err)
+			commandErr = commandError(gateway.ErrorInternal, "Internal error")
+		} else {
+			log.Printf("User %d %s rejected: %v", c.UserID, cmd.Type, commandErr)
+		}
+		if c.Version != gateway.Version1 {
+			c.reply(gateway.OpError, cmd, commandErr)
+		}
+		return
+	}
+
+	if c.Version != gateway.Version1 {
+		if ack == nil {
+			ack = &gateway.Ack{}
+		}
+		c.reply(gateway.OpAck, cmd, ack)
+	}
+}
+
+// reply sends a frame outside of the session events, it is neither numbered nor replayed
+func (c *Client) reply(op gateway.Op, cmd *command, data interface{}) {
+	envelope := gateway.Envelope{Op: op}
+	if cmd != nil {
+		envelope.Type = cmd.Type
+		envelope.Nonce = cmd.Nonce
+	}
+	envelope.Data, _ = json.Marshal(data)
+	payload, _ := json.Marshal(envelope)
+
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	if c.Send == nil {
+		return
+	}
+	select {
+	case c.Send <- payload:
+	default:
+		log.Printf("Client %d buffer full", c.UserID)
+	}
+}
+
+// encodeEvent numbers an event for the protocol version of the session. Events are built
+// as version 1 frames, later versions move their fields to the data of a dispatch Envelope.
+func encodeEvent(message []byte, version int, sequence uint64) []byte {
+	if version == gateway.Version1 {
+		return withSequence(message, sequence)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		log.Println("Invalid event:", err)
+		return message
+	}
+	envelope := gateway.Envelope{Op: gateway.OpDispatch, Seq: sequence}
+	json.Unmarshal(fields["type"], &envelope.Type)
+	delete(fields, "type")
+	envelope.Data, _ = json.Marshal(fields)
+
+	payload, _ := json.Marshal(envelope)
+	return payload
+}
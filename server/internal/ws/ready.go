@@ -0,0 +1,233 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+	"lesha.com/server/internal/entity"
+	"lesha.com/server/internal/services"
+)
+
+// setup subscribes a new session to the servers of the user and the channels they can view,
+// then sends the READY event with what the client needs to draw its sidebar
+func (c *Client) setup(db *gorm.DB, user *entity.User) {
+	servers, err := services.NewReadStateService(db).GetServersWithChannels(user.ID)
+	if err != nil {
+		log.Println("Failed to fetch servers:", err)
+		return
+	}
+	for _, server := range servers {
+		hub.JoinServer(c, server.ID, channelIDsOf(server.Channels))
+	}
+
+	// Direct messages are delivered to their recipients, they are only tracked for typing indicators
+	directChannels, err := services.NewChannelService(db).GetUserDirectChannels(user.ID)
+	if err != nil {
+		log.Println("Failed to fetch direct channels:", err)
+		return
+	}
+	for _, channel := range directChannels {
+		recipientIDs := []uint{user.ID}
+		for _, recipient := range channel.Recipients {
+			recipientIDs = append(recipientIDs, recipient.ID)
+		}
+		c.joinDirectChannel(channel.ID, recipientIDs)
+	}
+
+	visibleIDs, err := services.NewPresenceService(db).GetVisibleUserIDs(user.ID)
+	if err != nil {
+		log.Println("Failed to fetch presence users:", err)
+		return
+	}
+	visiblePresences := GetPresences(visibleIDs)
+	presences := make([]entity.PresenceResponse, 0, len(visiblePresences))
+	for _, visibleID := range visibleIDs {
+		if presence, ok := visiblePresences[visibleID]; ok {
+			presences = append(presences, entity.PresenceResponse{UserID: visibleID, Status: presence})
+		}
+	}
+
+	payload, _ := json.Marshal(struct {
+		Type           string                                `json:"type"`
+		SessionID      string                                `json:"session_id"`
+		User           entity.UserResponse                   `json:"user"`
+		Status         string                                `json:"status"`
+		Servers        []entity.ServerWithChannels           `json:"servers"`
+		DirectChannels []entity.DirectMessageChannelResponse `json:"direct_channels"`
+		Presences      []entity.PresenceResponse             `json:"presences"`
+	}{
+		Type:           "READY",
+		SessionID:      c.SessionID,
+		User:           user.ToResponse(),
+		Status:         user.Status,
+		Servers:        servers,
+		DirectChannels: directChannels,
+		Presences:      presences,
+	})
+	hub.pushTo(c, payload)
+}
+
+// AddToServer subscribes the sessions of a new member to a server and the channels they can
+// view, and sends them the server in a SERVER_CREATE event
+func AddToServer(db *gorm.DB, userID uint, server *entity.Server) {
+	serverWithChannels, err := services.NewReadStateService(db).GetServerWithChannels(userID, *server)
+	if err != nil {
+		log.Println("Failed to fetch server channels:", err)
+		return
+	}
+
+	payload, _ := json.Marshal(struct {
+		Type   string                    `json:"type"`
+		Server entity.ServerWithChannels `json:"server"`
+	}{
+		Type:   "SERVER_CREATE",
+		Server: *serverWithChannels,
+	})
+	change := SubscriptionChange{Servers: []uint{server.ID}, Channels: channelIDsOf(serverWithChannels.Channels)}
+	hub.UpdateUserSubscriptions(userID, change, payload)
+}
+
+// BroadcastServerUpdate sends the new settings of a server to its members
+func BroadcastServerUpdate(server *entity.Server) {
+	payload, _ := json.Marshal(struct {
+		Type   string                `json:"type"`
+		Server entity.ServerResponse `json:"server"`
+	}{
+		Type:   "SERVER_UPDATE",
+		Server: server.ToResponse(),
+	})
+	hub.BroadcastToServer(server.ID, payload)
+}
+
+// BroadcastServerDelete tells the members of a deleted server to drop it and unsubscribes them
+func BroadcastServerDelete(server *entity.Server, channelIDs []uint) {
+	payload, _ := json.Marshal(struct {
+		Type     string `json:"type"`
+		ServerID uint   `json:"server_id"`
+		Reason   string `json:"reason"`
+	}{
+		Type:     "SERVER_REMOVE",
+		ServerID: server.ID,
+		Reason:   "delete",
+	})
+	hub.CloseServer(server.ID, channelIDs, payload)
+}
+
+// BroadcastChannelCreate subscribes the members who can view a new channel and sends it to them
+func BroadcastChannelCreate(db *gorm.DB, channel *entity.Channel) {
+	syncChannel(db, "CHANNEL_CREATE", channel)
+}
+
+// BroadcastChannelUpdate sends a changed channel to the members who can view it, whether or not
+// they could before. Members who cannot view it after a permission change get a CHANNEL_DELETE.
+func BroadcastChannelUpdate(db *gorm.DB, channel *entity.Channel) {
+	syncChannel(db, "CHANNEL_UPDATE", channel)
+}
+
+// BroadcastChannelDelete tells the subscribers of a deleted channel to drop it and unsubscribes them
+func BroadcastChannelDelete(channel *entity.Channel) {
+	hub.CloseChannel(channel.ID, channelDeletePayload(channel))
+}
+
+// syncChannel checks which members of the server of a channel can view it, then updates their
+// subscriptions and sends them the channel
+func syncChannel(db *gorm.DB, eventType string, channel *entity.Channel) {
+	if channel.IsDirect() {
+		return
+	}
+
+	permissions, err := services.NewPermissionService(db).GetServerPermissionSet(*channel.ServerID)
+	if err != nil {
+		log.Println("Failed to fetch server permissions:", err)
+		return
+	}
+
+	var allowedIDs []uint
+	for _, memberID := range permissions.MemberIDs() {
+		if permissions.CanAccessChannel(channel.ID, memberID) {
+			allowedIDs = append(allowedIDs, memberID)
+		} else if eventType != "CHANNEL_CREATE" {
+			change := SubscriptionChange{Channels: []uint{channel.ID}, Leave: true}
+			hub.UpdateUserSubscriptions(memberID, change, channelDeletePayload(channel))
+		}
+	}
+	sendChannel(db, eventType, channel, allowedIDs)
+}
+
+// SyncChannelAccess compares the channels of a server its members can view with those they
+// could view before a change of roles. Members are subscribed to the channels they gained,
+// which are sent in a CHANNEL_CREATE event, and get a CHANNEL_DELETE for those they lost.
+func SyncChannelAccess(db *gorm.DB, serverID uint, before *services.ServerPermissionSet) {
+	after, err := services.NewPermissionService(db).GetServerPermissionSet(serverID)
+	if err != nil {
+		log.Println("Failed to fetch server permissions:", err)
+		return
+	}
+	channels, err := services.NewChannelService(db).GetServerChannels(fmt.Sprintf("%d", serverID))
+	if err != nil {
+		log.Println("Failed to fetch server channels:", err)
+		return
+	}
+
+	for i := range channels {
+		channel := &channels[i]
+		var gainedIDs []uint
+		for _, memberID := range after.MemberIDs() {
+			could := before.CanAccessChannel(channel.ID, memberID)
+			can := after.CanAccessChannel(channel.ID, memberID)
+			if can && !could {
+				gainedIDs = append(gainedIDs, memberID)
+			} else if could && !can {
+				change := SubscriptionChange{Channels: []uint{channel.ID}, Leave: true}
+				hub.UpdateUserSubscriptions(memberID, change, channelDeletePayload(channel))
+			}
+		}
+		sendChannel(db, "CHANNEL_CREATE", channel, gainedIDs)
+	}
+}
+
+// sendChannel subscribes users to a channel and sends it to each of them with their read state
+func sendChannel(db *gorm.DB, eventType string, channel *entity.Channel, userIDs []uint) {
+	if len(userIDs) == 0 {
+		return
+	}
+	channels, err := services.NewReadStateService(db).GetChannelReadStates(*channel, userIDs)
+	if err != nil {
+		log.Println("Failed to fetch read states:", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		payload, _ := json.Marshal(struct {
+			Type    string                      `json:"type"`
+			Channel entity.ChannelWithReadState `json:"channel"`
+		}{
+			Type:    eventType,
+			Channel: channels[userID],
+		})
+		hub.UpdateUserSubscriptions(userID, SubscriptionChange{Channels: []uint{channel.ID}}, payload)
+	}
+}
+
+func channelDeletePayload(channel *entity.Channel) []byte {
+	payload, _ := json.Marshal(struct {
+		Type      string `json:"type"`
+		ChannelID uint   `json:"channel_id"`
+		ServerID  *uint  `json:"server_id"`
+	}{
+		Type:      "CHANNEL_DELETE",
+		ChannelID: channel.ID,
+		ServerID:  channel.ServerID,
+	})
+	return payload
+}
+
+func channelIDsOf(channels []entity.ChannelWithReadState) []uint {
+	channelIDs := make([]uint, len(channels))
+	for i, channel := range channels {
+		channelIDs[i] = channel.ID
+	}
+	return channelIDs
+}
@@ -0,0 +1,173 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"lesha.com/server/internal/entity"
+)
+
+// RedisChannel is the Redis pub/sub channel shared by the server instances
+const RedisChannel = "lesha:gateway"
+
+// swapPresenceScript replaces the presence last broadcast for a user and returns the previous one
+var swapPresenceScript = redis.NewScript(`
+local previous = redis.call('HGET', KEYS[1], 'presence')
+if ARGV[1] == ARGV[3] then
+	redis.call('HDEL', KEYS[1], 'presence')
+else
+	redis.call('HSET', KEYS[1], 'presence', ARGV[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return previous
+`)
+
+// RedisBroker relays the events of every server instance through Redis pub/sub. It keeps
+// the presence of each user in a hash holding their status, the presence last broadcast,
+// and the connections of each instance along with when they expire.
+type RedisBroker struct {
+	client     *redis.Client
+	pubsub     *redis.PubSub
+	instanceID string
+}
+
+// NewRedisBroker connects to the Redis server of a URL such as redis://localhost:6379/0
+func NewRedisBroker(url string) (*RedisBroker, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisBroker{client: client, instanceID: newSessionID()}, nil
+}
+
+func (broker *RedisBroker) Publish(event BrokerEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return broker.client.Publish(context.Background(), RedisChannel, payload).Err()
+}
+
+// Subscribe hands the events to the handler from a single goroutine, in the order Redis received
+// them. A broker has a single subscriber.
+func (broker *RedisBroker) Subscribe(handler func(event BrokerEvent)) error {
+	pubsub := broker.client.Subscribe(context.Background(), RedisChannel)
+	// Wait for the subscription so that no event published afterwards is missed
+	if _, err := pubsub.Receive(context.Background()); err != nil {
+		pubsub.Close()
+		return err
+	}
+	broker.pubsub = pubsub
+
+	go func() {
+		for message := range pubsub.Channel() {
+			var event BrokerEvent
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				log.Println("Invalid broker event:", err)
+				continue
+			}
+			handler(event)
+		}
+	}()
+	return nil
+}
+
+func (broker *RedisBroker) Close() error {
+	if broker.pubsub != nil {
+		broker.pubsub.Close()
+	}
+	return broker.client.Close()
+}
+
+func (broker *RedisBroker) SetStatus(userID uint, status string) error {
+	key := presenceKey(userID)
+	pipe := broker.client.TxPipeline()
+	pipe.HSet(context.Background(), key, "status", status)
+	pipe.PExpire(context.Background(), key, PresenceTTL)
+	_, err := pipe.Exec(context.Background())
+	return err
+}
+
+func (broker *RedisBroker) SetConnections(userID uint, connected bool, idle bool) error {
+	key := presenceKey(userID)
+	field := "instance:" + broker.instanceID
+	if !connected {
+		return broker.client.HDel(context.Background(), key, field).Err()
+	}
+
+	state := "active"
+	if idle {
+		state = "idle"
+	}
+	expiresAt := time.Now().Add(PresenceTTL).UnixMilli()
+	pipe := broker.client.TxPipeline()
+	pipe.HSet(context.Background(), key, field, fmt.Sprintf("%s|%d", state, expiresAt))
+	pipe.PExpire(context.Background(), key, PresenceTTL)
+	_, err := pipe.Exec(context.Background())
+	return err
+}
+
+// GetPresences leaves out the connections of the instances that stopped renewing them
+func (broker *RedisBroker) GetPresences(userIDs []uint) (map[uint]SharedPresence, error) {
+	presences := make(map[uint]SharedPresence)
+	if len(userIDs) == 0 {
+		return presences, nil
+	}
+
+	pipe := broker.client.Pipeline()
+	commands := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		commands[i] = pipe.HGetAll(context.Background(), presenceKey(userID))
+	}
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	for i, command := range commands {
+		connected := false
+		presence := SharedPresence{Status: command.Val()["status"], Idle: true}
+		for field, value := range command.Val() {
+			if !strings.HasPrefix(field, "instance:") {
+				continue
+			}
+			state, expiry, _ := strings.Cut(value, "|")
+			if expiresAt, err := strconv.ParseInt(expiry, 10, 64); err != nil || expiresAt < now {
+				continue
+			}
+			connected = true
+			if state == "active" {
+				presence.Idle = false
+			}
+		}
+		if connected {
+			presences[userIDs[i]] = presence
+		}
+	}
+	return presences, nil
+}
+
+func (broker *RedisBroker) SwapPresence(userID uint, presence string) (string, error) {
+	keys := []string{presenceKey(userID)}
+	previous, err := swapPresenceScript.Run(context.Background(), broker.client, keys, presence, PresenceTTL.Milliseconds(), entity.PresenceOffline).Text()
+	if err == redis.Nil {
+		return entity.PresenceOffline, nil
+	}
+	return previous, err
+}
+
+func presenceKey(userID uint) string {
+	return fmt.Sprintf("%s:presence:%d", RedisChannel, userID)
+}
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"lesha.com/server/internal/entity"
+)
+
+// newTestRedisBroker connects to REDIS_URL, or to a local Redis server, and skips the test
+// when none is reachable
+func newTestRedisBroker(t *testing.T) *RedisBroker {
+	t.Helper()
+
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		url = "redis://localhost:6379/0"
+	}
+	broker, err := NewRedisBroker(url)
+	if err != nil {
+		t.Skipf("Redis is unreachable at %s: %v", url, err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	return broker
+}
+
+// testUserID returns a user ID unlikely to be used by another run against the same Redis server
+func testUserID() uint {
+	return uint(time.Now().UnixNano()%1000000000) + 1000000000
+}
+
+func TestRedisBrokerDeliversAcrossHubs(t *testing.T) {
+	first := NewHub(newTestRedisBroker(t))
+	second := NewHub(newTestRedisBroker(t))
+
+	userID := testUserID()
+	channelID := userID
+	client := newTestClient(userID)
+	second.Register(client)
+	second.JoinChannel(client, channelID)
+
+	first.BroadcastToChannel(channelID, []byte(`{"type":"A"}`))
+	if got := receive(t, client); got != `{"seq":1,"type":"A"}` {
+		t.Errorf("received %s", got)
+	}
+
+	// Subscription changes published by an instance apply to the sessions of the others
+	first.UpdateUserSubscriptions(userID, SubscriptionChange{Servers: []uint{channelID}, Channels: []uint{channelID + 1}}, []byte(`{"type":"B"}`))
+	receive(t, client)
+	first.BroadcastToChannel(channelID+1, []byte(`{"type":"C"}`))
+	if got := receive(t, client); got != `{"seq":3,"type":"C"}` {
+		t.Errorf("received %s after joining a channel from another instance", got)
+	}
+
+	first.CloseChannel(channelID, []byte(`{"type":"D"}`))
+	receive(t, client)
+	if second.IsSubscribed(client, channelID) {
+		t.Errorf("the session is still subscribed to a channel closed from another instance")
+	}
+}
+
+func TestRedisBrokerSharesPresence(t *testing.T) {
+	first := newTestRedisBroker(t)
+	second := newTestRedisBroker(t)
+	userID := testUserID()
+	t.Cleanup(func() { first.client.Del(context.Background(), presenceKey(userID)) })
+
+	sharedPresence := func() (SharedPresence, bool) {
+		t.Helper()
+
+		presences, err := second.GetPresences([]uint{userID, userID + 1})
+		if err != nil {
+			t.Fatalf("GetPresences failed: %v", err)
+		}
+		if _, ok := presences[userID+1]; ok {
+			t.Errorf("GetPresences returned a presence for a user without connections")
+		}
+		presence, ok := presences[userID]
+		return presence, ok
+	}
+
+	if err := first.SetStatus(userID, entity.PresenceDND); err != nil {
+		t.Fatalf("SetStatus failed: %v", err)
+	}
+	if err := first.SetConnections(userID, true, true); err != nil {
+		t.Fatalf("SetConnections failed: %v", err)
+	}
+	if presence, ok := sharedPresence(); !ok || presence.Status != entity.PresenceDND || !presence.Idle {
+		t.Errorf("the presence of an idle user is %+v, %v", presence, ok)
+	}
+
+	// The user is active as soon as one instance has an active connection
+	if err := second.SetConnections(userID, true, false); err != nil {
+		t.Fatalf("SetConnections failed: %v", err)
+	}
+	if presence, ok := sharedPresence(); !ok || presence.Idle {
+		t.Errorf("the presence of a user active on another instance is %+v, %v", presence, ok)
+	}
+
+	if err := second.SetConnections(userID, false, false); err != nil {
+		t.Fatalf("SetConnections failed: %v", err)
+	}
+	if presence, ok := sharedPresence(); !ok || !presence.Idle {
+		t.Errorf("the presence of a user still idle on an instance is %+v, %v", presence, ok)
+	}
+	if err := first.SetConnections(userID, false, false); err != nil {
+		t.Fatalf("SetConnections failed: %v", err)
+	}
+	if presence, ok := sharedPresence(); ok {
+		t.Errorf("a user without connections has the presence %+v", presence)
+	}
+
+	if previous, err := first.SwapPresence(userID, entity.PresenceOnline); err != nil || previous != entity.PresenceOffline {
+		t.Errorf("SwapPresence returned %q, %v, want offline", previous, err)
+	}
+	if previous, err := second.SwapPresence(userID, entity.PresenceOffline); err != nil || previous != entity.PresenceOnline {
+		t.Errorf("SwapPresence returned %q, %v, want the presence broadcast by the other instance", previous, err)
+	}
+}
@@ -0,0 +1,227 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"lesha.com/server/pkg/gateway"
+)
+
+const (
+	// WriteWait is the time allowed to write a frame to the client
+	WriteWait = 10 * time.Second
+	// PongWait is the time allowed to read the next pong from the client
+	PongWait = 60 * time.Second
+	// PingInterval is how often the server pings the client, it must be less than PongWait
+	PingInterval = PongWait * 9 / 10
+	// SessionBufferSize is the number of sent events kept to be replayed on RESUME
+	SessionBufferSize = 200
+	// SessionResumeTimeout is how long the session of a closed connection can be resumed
+	SessionResumeTimeout = 2 * time.Minute
+)
+
+// sequencedEvent is a frame sent to a session along with its sequence number
+type sequencedEvent struct {
+	Sequence uint64
+	Payload  []byte
+}
+
+func newSessionID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Println("Failed to generate session ID:", err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// push numbers an event with the next sequence number of the session, keeps it for replay
+// and queues it on the connection when there is one. The hub lock must be held.
+func (c *Client) push(message []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sequence++
+	payload := encodeEvent(message, c.Version, c.sequence)
+	c.buffer = append(c.buffer, sequencedEvent{Sequence: c.sequence, Payload: payload})
+	if len(c.buffer) > SessionBufferSize {
+		c.buffer = c.buffer[len(c.buffer)-SessionBufferSize:]
+	}
+
+	if c.Send == nil {
+		return
+	}
+	select {
+	case c.Send <- payload:
+	default:
+		log.Printf("Client %d buffer full", c.UserID)
+	}
+}
+
+// withSequence adds the seq field to a JSON object frame
+func withSequence(message []byte, sequence uint64) []byte {
+	if len(message) < 2 || message[0] != '{' {
+		return message
+	}
+	field := fmt.Sprintf(`{"seq":%d`, sequence)
+	if len(message) == 2 {
+		return []byte(field + "}")
+	}
+	return append([]byte(field+","), message[1:]...)
+}
+
+// eventsSince returns the buffered events after a sequence number, it fails when some of them
+// are no longer buffered. The client mutex must be held.
+func (c *Client) eventsSince(sequence uint64) ([]sequencedEvent, bool) {
+	if sequence > c.sequence {
+		return nil, false
+	}
+	if sequence == c.sequence {
+		return nil, true
+	}
+	if len(c.buffer) == 0 || c.buffer[0].Sequence > sequence+1 {
+		return nil, false
+	}
+	for i, event := range c.buffer {
+		if event.Sequence > sequence {
+			return c.buffer[i:], true
+		}
+	}
+	return nil, true
+}
+
+// Detach closes the connection side of a session. Events sent to the session are still
+// buffered until it is resumed or SessionResumeTimeout passes. expired runs when the session
+// then gets unregistered and it was the last session of the user.
+func (h *Hub) Detach(client *Client, expired func()) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if client.Send != nil {
+		close(client.Send)
+		client.Send = nil
+	}
+	time.AfterFunc(SessionResumeTimeout, func() {
+		if h.Unregister(client) && expired != nil {
+			expired()
+		}
+	})
+}
+
+// Resume moves a closed session of the same user onto the client: its subscriptions,
+// sequence number and buffered events. The events after the last sequence number the
+// client received are replayed. It fails when the session cannot be resumed, which includes
+// the sessions opened on another instance.
+func (h *Hub) Resume(client *Client, sessionID string, sequence uint64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	previous, ok := h.sessions[sessionID]
+	if !ok || previous == client || previous.UserID != client.UserID || previous.Version != client.Version || previous.Send != nil {
+		return false
+	}
+
+	previous.mutex.Lock()
+	events, ok := previous.eventsSince(sequence)
+	lastSequence := previous.sequence
+	buffer := previous.buffer
+	previous.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	for serverID := range previous.Servers {
+		subscribe(h.servers, serverID, client)
+		client.Servers[serverID] = true
+	}
+	for channelID := range previous.Channels {
+		subscribe(h.channels, channelID, client)
+		client.Channels[channelID] = true
+	}
+	for threadID := range previous.Threads {
+		subscribe(h.threads, threadID, client)
+		client.Threads[threadID] = true
+	}
+	for channelID, recipientIDs := range previous.DirectChannels {
+		client.DirectChannels[channelID] = recipientIDs
+	}
+	h.remove(previous)
+
+	delete(h.sessions, client.SessionID)
+	client.SessionID = sessionID
+	h.sessions[sessionID] = client
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.sequence = lastSequence
+	client.buffer = append([]sequencedEvent(nil), buffer...)
+	for _, event := range events {
+		select {
+		case client.Send <- event.Payload:
+		default:
+			log.Printf("Client %d buffer full", client.UserID)
+		}
+	}
+	return true
+}
+
+// sendHello tells a new connection its session ID and how often the server pings it
+func (c *Client) sendHello() {
+	if c.Version != gateway.Version1 {
+		c.reply(gateway.OpHello, nil, gateway.Hello{
+			SessionID:         c.SessionID,
+			HeartbeatInterval: PingInterval.Milliseconds(),
+			Version:           c.Version,
+		})
+		return
+	}
+
+	payload, _ := json.Marshal(struct {
+		Type              string `json:"type"`
+		SessionID         string `json:"session_id"`
+		HeartbeatInterval int64  `json:"heartbeat_interval"`
+	}{
+		Type:              "HELLO",
+		SessionID:         c.SessionID,
+		HeartbeatInterval: PingInterval.Milliseconds(),
+	})
+	hub.pushTo(c, payload)
+}
+
+// resume handles a RESUME frame, the client is told to re-sync through the REST API
+// when the session is gone or too many events were missed
+func (c *Client) resume(cmd *command) (*gateway.Ack, error) {
+	var data gateway.Resume
+	if err := cmd.decode(&data); err != nil {
+		return nil, err
+	}
+	sessionID := data.SessionID
+
+	if !hub.Resume(c, sessionID, data.Seq) {
+		if c.Version == gateway.Version1 {
+			payload, _ := json.Marshal(struct {
+				Type      string `json:"type"`
+				SessionID string `json:"session_id"`
+			}{
+				Type:      "INVALID_SESSION",
+				SessionID: c.SessionID,
+			})
+			hub.pushTo(c, payload)
+		}
+		return nil, commandError(gateway.ErrorInvalidSession, "Session %s cannot be resumed", sessionID)
+	}
+
+	payload, _ := json.Marshal(struct {
+		Type      string `json:"type"`
+		SessionID string `json:"session_id"`
+	}{
+		Type:      "RESUMED",
+		SessionID: sessionID,
+	})
+	hub.pushTo(c, payload)
+	return nil, nil
+}
@@ -0,0 +1,117 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// TypingRateLimit is the minimum delay between two TYPING_START frames of a user in a channel
+	TypingRateLimit = 3 * time.Second
+	// TypingTimeout is how long a user is shown typing after their last TYPING_START
+	TypingTimeout = 8 * time.Second
+)
+
+type typingKey struct {
+	UserID    uint
+	ChannelID uint
+}
+
+type typingState struct {
+	startedAt time.Time
+	timer     *time.Timer
+	send      func(payload []byte)
+}
+
+// typingStates holds the users currently typing, it is only kept in memory by the instance
+// the users typed on
+var typingStates = make(map[typingKey]*typingState)
+
+// typingMutex guards typingStates
+var typingMutex sync.Mutex
+
+// startTyping shows the user typing in a channel until TypingTimeout, ignoring the
+// frames sent faster than TypingRateLimit. send reaches the other subscribers of the channel.
+func startTyping(userID uint, channelID uint, send func(payload []byte)) {
+	key := typingKey{UserID: userID, ChannelID: channelID}
+
+	typingMutex.Lock()
+	defer typingMutex.Unlock()
+
+	if state, ok := typingStates[key]; ok {
+		if time.Since(state.startedAt) < TypingRateLimit {
+			return
+		}
+		state.timer.Stop()
+	}
+
+	state := &typingState{startedAt: time.Now(), send: send}
+	state.timer = time.AfterFunc(TypingTimeout, func() {
+		typingMutex.Lock()
+		defer typingMutex.Unlock()
+
+		if typingStates[key] == state {
+			delete(typingStates, key)
+			state.send(typingPayload("TYPING_STOP", key))
+		}
+	})
+	typingStates[key] = state
+	send(typingPayload("TYPING_START", key))
+}
+
+// StopTyping ends the typing indicator of the user in a channel, once their message is sent
+func StopTyping(userID uint, channelID uint) {
+	key := typingKey{UserID: userID, ChannelID: channelID}
+
+	typingMutex.Lock()
+	defer typingMutex.Unlock()
+
+	state, ok := typingStates[key]
+	if !ok {
+		return
+	}
+	state.timer.Stop()
+	delete(typingStates, key)
+	state.send(typingPayload("TYPING_STOP", key))
+}
+
+func typingPayload(eventType string, key typingKey) []byte {
+	payload, _ := json.Marshal(struct {
+		Type      string    `json:"type"`
+		ChannelID uint      `json:"channel_id"`
+		UserID    uint      `json:"user_id"`
+		Timestamp time.Time `json:"timestamp"`
+	}{
+		Type:      eventType,
+		ChannelID: key.ChannelID,
+		UserID:    key.UserID,
+		Timestamp: time.Now(),
+	})
+	return payload
+}
+
+// typingAudience returns how to reach the other users subscribed to a channel the client
+// joined, without looking it up in the database. It is nil when the client did not join it.
+func (c *Client) typingAudience(channelID uint) func(payload []byte) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	userID := c.UserID
+	if recipientIDs, ok := c.DirectChannels[channelID]; ok {
+		return func(payload []byte) {
+			for _, recipientID := range recipientIDs {
+				if recipientID != userID {
+					SendToUser(recipientID, payload)
+				}
+			}
+		}
+	}
+
+	if !c.Channels[channelID] {
+		return nil
+	}
+	return func(payload []byte) {
+		hub.BroadcastToChannelExcept(channelID, userID, payload)
+	}
+}
@@ -8,26 +8,48 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"gorm.io/gorm"
 	"lesha.com/server/internal/entity"
 	"lesha.com/server/internal/services"
+	"lesha.com/server/pkg/gateway"
 )
 
-var ChannelClients = make(map[string]map[*Client]bool)
-
+// Client is a WebSocket session of a user. It outlives its connection for SessionResumeTimeout
+// so that a new connection can resume it. Its connection, subscriptions and idle state are
+// guarded by the hub lock, its sequence number and buffered events by its own mutex.
 type Client struct {
-	Conn     *websocket.Conn
-	Send     chan []byte
-	UserID   uint
-	Channels map[string]bool
+	Conn      *websocket.Conn
+	Send      chan []byte // nil once the connection is closed
+	UserID    uint
+	SessionID string
+	Version   int // Gateway protocol version chosen when connecting
+	Servers   map[uint]bool
+	Channels  map[uint]bool
+	Threads   map[uint]bool
+	Idle      bool // Reported by the client when the user is away
+
+	DirectChannels map[uint][]uint // Recipients of the joined direct message channels by ID
+
+	mutex    sync.Mutex
+	sequence uint64           // Sequence number of the last event sent to the session
+	buffer   []sequencedEvent // Last events sent, replayed on RESUME
 }
 
+// readPump handles the frames of the client until the connection fails or the client
+// stops answering pings
 func (c *Client) readPump(db *gorm.DB) {
 	defer func() { c.Conn.Close() }()
+
+	c.Conn.SetReadDeadline(time.Now().Add(PongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(PongWait))
+	})
 	for {
 		_, msg, err := c.Conn.ReadMessage()
 		if err != nil {
@@ -38,43 +60,56 @@ func (c *Client) readPump(db *gorm.DB) {
 	}
 }
 
-func (c *Client) writePump() {
+// writePump writes the queued frames to the connection and pings it every PingInterval
+func (c *Client) writePump(conn *websocket.Conn, send chan []byte) {
+	ticker := time.NewTicker(PingInterval)
 	defer func() {
-		c.Conn.Close()
+		ticker.Stop()
+		conn.Close()
 	}()
 
 	for {
 		select {
-		case msg, ok := <-c.Send:
+		case msg, ok := <-send:
+			conn.SetWriteDeadline(time.Now().Add(WriteWait))
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			err := c.Conn.WriteMessage(websocket.TextMessage, msg)
+			err := conn.WriteMessage(websocket.TextMessage, msg)
 			if err != nil {
 				log.Println("write error:", err)
 				return
 			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Println("ping error:", err)
+				return
+			}
 		}
 	}
 }
 
-func (c *Client) joinChannel(channelName string) {
-	if _, ok := ChannelClients[channelName]; !ok {
-		ChannelClients[channelName] = make(map[*Client]bool)
-	}
-	ChannelClients[channelName][c] = true
-	c.Channels[channelName] = true
+// joinDirectChannel remembers the recipients of a direct message channel, which are
+// sent its messages without joining it
+func (c *Client) joinDirectChannel(channelID uint, recipientIDs []uint) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
 
-	log.Printf("User %d joined channel %s", c.UserID, channelName)
+	c.DirectChannels[channelID] = recipientIDs
 }
 
 func HandleWebSocket(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := Upgrade(w, r)
-		if err != nil {
-			http.Error(w, "Could not open WebSocket connection", http.StatusBadRequest)
-			return
+		version := gateway.DefaultVersion
+		if value := r.URL.Query().Get("v"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || !gateway.IsSupportedVersion(parsed) {
+				http.Error(w, "Unsupported gateway version", http.StatusBadRequest)
+				return
+			}
+			version = parsed
 		}
 
 		cookie, err := r.Cookie("token")
@@ -85,228 +120,660 @@ func HandleWebSocket(db *gorm.DB) http.HandlerFunc {
 		tokenString := cookie.Value
 		user, err := services.ExtractUserFromToken(tokenString)
 		if err != nil {
+			http.Error(w, "Failed to get user", http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, "Could not open WebSocket connection", http.StatusBadRequest)
 			return
 		}
 
 		client := &Client{
-			Conn:     conn,
-			Send:     make(chan []byte, 256),
-			UserID:   user.ID,
-			Channels: make(map[string]bool),
+			Conn:      conn,
+			Send:      make(chan []byte, 256),
+			UserID:    user.ID,
+			SessionID: newSessionID(),
+			Version:   version,
+			Servers:   make(map[uint]bool),
+			Channels:  make(map[uint]bool),
+			Threads:   make(map[uint]bool),
+
+			DirectChannels: make(map[uint][]uint),
 		}
 
-		if err := db.Preload("Servers.Channels").First(&user, user.ID).Error; err != nil {
-			log.Println("failed to fetch user servers/channels:", err)
+		if err := db.First(&user, user.ID).Error; err != nil {
+			log.Println("failed to fetch user:", err)
 			return
 		}
 
-		go client.writePump()
+		hub.Register(client)
+		setUserStatus(user.ID, user.Status)
+		updatePresence(db, user.ID)
+		defer func() {
+			hub.Detach(client, func() {
+				removeTemporaryMemberships(db, user.ID)
+			})
+			updatePresence(db, user.ID)
+		}()
+
+		go client.writePump(conn, client.Send)
+		client.sendHello()
+		client.setup(db, user)
 		client.readPump(db)
 	}
 }
 
-func (c *Client) handleMessage(db *gorm.DB, raw []byte) {
-	var incoming struct {
-		Type      string `json:"type"`
-		ChannelID uint   `json:"channel_id"`
-		MessageID uint   `json:"message_id"`
-		Content   string `json:"content"`
-		File      string `json:"file"`
-		Filename  string `json:"filename"`
-		Reaction  string `json:"reaction"`
+// removeTemporaryMemberships drops the user from the servers they joined with a temporary
+// invite once their last session expired, and tells the other instances to unsubscribe them
+func removeTemporaryMemberships(db *gorm.DB, userID uint) {
+	serverIDs, err := services.NewServerService(db).RemoveTemporaryMemberships(userID)
+	if err != nil {
+		log.Println("failed to remove temporary memberships:", err)
+	}
+
+	channelService := services.NewChannelService(db)
+	for _, serverID := range serverIDs {
+		channels, err := channelService.GetServerChannels(fmt.Sprintf("%d", serverID))
+		if err != nil {
+			log.Println("failed to fetch server channels:", err)
+			continue
+		}
+		channelIDs := make([]uint, len(channels))
+		for i, channel := range channels {
+			channelIDs[i] = channel.ID
+		}
+		RemoveFromServer(userID, serverID, channelIDs, "leave")
 	}
+}
 
-	if err := json.Unmarshal(raw, &incoming); err != nil {
-		log.Println("Invalid message:", err)
+func (c *Client) handleMessage(db *gorm.DB, raw []byte) {
+	cmd, err := c.parseCommand(raw)
+	if err != nil {
+		if cmd == nil {
+			cmd = &command{}
+		}
+		c.replyTo(cmd, nil, err)
 		return
 	}
 
-	fmt.Println(incoming)
+	var ack *gateway.Ack
+	switch cmd.Type {
+	case gateway.TypeResume:
+		ack, err = c.resume(cmd)
+	case gateway.TypeMessage:
+		ack, err = c.createMessage(db, cmd)
+	case gateway.TypeJoinChannel:
+		err = c.joinChannel(db, cmd)
+	case gateway.TypeLeaveChannel:
+		err = c.leaveChannel(cmd)
+	case gateway.TypeJoinThread:
+		err = c.joinThread(db, cmd)
+	case gateway.TypeReaction:
+		err = c.addReaction(db, cmd)
+	case gateway.TypeMessageEdit:
+		err = c.editMessage(db, cmd)
+	case gateway.TypeMessageDelete:
+		err = c.deleteMessage(db, cmd)
+	case gateway.TypeMarkRead:
+		ack, err = c.markRead(db, cmd)
+	case gateway.TypePresenceUpdate:
+		err = c.changePresence(db, cmd)
+	case gateway.TypeTypingStart:
+		err = c.sendTyping(cmd)
+	default:
+		err = commandError(gateway.ErrorUnknownType, "Unknown command type %q", cmd.Type)
+	}
+	c.replyTo(cmd, ack, err)
+}
 
-	switch incoming.Type {
-	case "MESSAGE":
-		messageService := services.NewMessageService(db)
-		message := entity.Message{
-			UserID:    c.UserID,
-			ChannelID: incoming.ChannelID,
-			Content:   incoming.Content,
-			Pinned:    false,
-		}
+func (c *Client) createMessage(db *gorm.DB, cmd *command) (*gateway.Ack, error) {
+	var data gateway.Message
+	if err := cmd.decode(&data); err != nil {
+		return nil, err
+	}
 
-		if err := messageService.CreateMessage(&message); err != nil {
-			log.Println("Failed to save message:", err)
-			return
+	permissionService := services.NewPermissionService(db)
+	messageService := services.NewMessageService(db)
+	message := entity.Message{
+		UserID:    c.UserID,
+		ChannelID: data.ChannelID,
+		Content:   data.Content,
+		Pinned:    false,
+	}
+
+	// Messages posted in a thread are stored in the thread's parent channel
+	var thread *entity.Thread
+	if data.ThreadID != 0 {
+		var err error
+		thread, err = services.NewThreadService(db).AttachMessage(&message, data.ThreadID)
+		if err != nil {
+			return nil, lookupError(err, "Unknown thread %d", data.ThreadID)
 		}
+		data.ChannelID = message.ChannelID
+	}
+	if data.ReferencedMessageID != 0 {
+		message.ReferencedMessageID = &data.ReferencedMessageID
+	}
 
-		if incoming.File != "" && incoming.Filename != "" {
-			parts := strings.SplitN(incoming.File, ",", 2)
-			if len(parts) != 2 {
-				log.Println("Invalid base64 data")
-				return
-			}
+	allowed, err := permissionService.HasChannelPermission(data.ChannelID, c.UserID, entity.PermissionViewChannel|entity.PermissionSendMessages)
+	if err := permissionError(allowed, err, "Not allowed to send messages in channel %d", data.ChannelID); err != nil {
+		return nil, err
+	}
 
-			decoded, err := base64.StdEncoding.DecodeString(parts[1])
-			if err != nil {
-				log.Println("Failed to decode base64:", err)
-				return
-			}
+	var upload *mediaUpload
+	if data.File != "" && data.Filename != "" {
+		allowed, err := permissionService.HasChannelPermission(data.ChannelID, c.UserID, entity.PermissionAttachFiles)
+		if err := permissionError(allowed, err, "Not allowed to attach files in channel %d", data.ChannelID); err != nil {
+			return nil, err
+		}
+		upload, err = decodeUpload(data.File, data.Filename)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			uploadDir := "uploads/messages"
-			if err := os.MkdirAll(uploadDir, 0755); err != nil {
-				log.Println("Failed to create upload directory:", err)
-				return
-			}
+	if err := messageService.ValidateReference(&message); err != nil {
+		return nil, commandError(gateway.ErrorInvalidData, "%v", err)
+	}
 
-			filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), incoming.Filename)
-			filePath := filepath.Join(uploadDir, filename)
+	// The media is inserted along with the message, so a failed upload leaves no message behind
+	if upload != nil {
+		media, err := upload.write()
+		if err != nil {
+			return nil, err
+		}
+		message.Medias = append(message.Medias, *media)
+	}
 
-			file, err := os.Create(filePath)
-			if err != nil {
-				log.Println("Failed to create file:", err)
-				return
-			}
-			defer file.Close()
+	if err := messageService.CreateMessage(&message); err != nil {
+		for _, media := range message.Medias {
+			os.Remove(media.Url)
+		}
+		return nil, err
+	}
+	StopTyping(c.UserID, message.ChannelID)
 
-			if _, err := file.Write(decoded); err != nil {
-				log.Println("Failed to write file:", err)
-				return
-			}
+	if thread != nil {
+		if err := services.NewThreadService(db).RecordMessage(thread, &message); err != nil {
+			log.Println("Failed to record thread activity:", err)
+		}
+	}
 
-			var ext string
-			for _, e := range []string{".jpg", ".jpeg", ".png", ".gif", ".mp4", ".webm", ".mp3", ".wav"} {
-				if strings.HasSuffix(strings.ToLower(incoming.Filename), e) {
-					ext = e
-					break
-				}
-			}
+	updatedMessage, err := messageService.GetMessage(fmt.Sprintf("%d", message.ID))
+	if err != nil {
+		return nil, err
+	}
 
-			if ext == "" {
-				log.Println("Invalid file extension")
-				return
-			}
+	messageResponse := updatedMessage.ToResponse()
+
+	NotifyMentions(updatedMessage, message.MentionedUsers)
+
+	payload, _ := json.Marshal(struct {
+		Type              string                           `json:"type"`
+		ID                uint                             `json:"id"`
+		ChannelID         uint                             `json:"channel_id"`
+		ThreadID          *uint                            `json:"thread_id"`
+		SenderID          uint                             `json:"sender"`
+		User              entity.UserResponse              `json:"user"`
+		Content           string                           `json:"content"`
+		Timestamp         time.Time                        `json:"timestamp"`
+		Medias            []entity.MediaResponse           `json:"medias"`
+		ReferencedMessage *entity.MessageReferenceResponse `json:"referenced_message"`
+	}{
+		Type:              "MESSAGE",
+		ID:                messageResponse.ID,
+		ChannelID:         messageResponse.ChannelID,
+		ThreadID:          messageResponse.ThreadID,
+		SenderID:          messageResponse.User.ID,
+		User:              messageResponse.User,
+		Content:           messageResponse.Content,
+		Timestamp:         messageResponse.CreatedAt,
+		Medias:            messageResponse.Medias,
+		ReferencedMessage: messageResponse.ReferencedMessage,
+	})
+
+	if thread != nil {
+		broadcastToThread(thread.ID, payload)
+	} else {
+		broadcastToChannel(db, message.ChannelID, payload)
+	}
+	return &gateway.Ack{MessageID: message.ID}, nil
+}
 
-			var mediaType string
-			switch ext {
-			case ".jpg", ".jpeg", ".png", ".gif":
-				mediaType = "image"
-			case ".mp4", ".webm":
-				mediaType = "video"
-			case ".mp3", ".wav":
-				mediaType = "audio"
-			default:
-				log.Println("Unsupported file type")
-				return
-			}
+// mediaUpload is a file sent along with a MESSAGE frame, checked before the message is saved
+type mediaUpload struct {
+	Filename  string
+	Content   []byte
+	Type      string
+	Extension string
+}
 
-			media := entity.Media{
-				MessageID: message.ID,
-				Type:      mediaType,
-				Extension: ext[1:],
-				Url:       filePath,
-			}
+func decodeUpload(file string, filename string) (*mediaUpload, error) {
+	parts := strings.SplitN(file, ",", 2)
+	if len(parts) != 2 {
+		return nil, commandError(gateway.ErrorInvalidData, "Invalid base64 data")
+	}
 
-			if err := messageService.AddMedia(&media); err != nil {
-				log.Println("Failed to save media:", err)
-				return
-			}
-		}
+	decoded, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, commandError(gateway.ErrorInvalidData, "Failed to decode base64")
+	}
 
-		updatedMessage, err := messageService.GetMessage(fmt.Sprintf("%d", message.ID))
-		if err != nil {
-			log.Println("Failed to get updated message:", err)
+	var ext string
+	for _, e := range []string{".jpg", ".jpeg", ".png", ".gif", ".mp4", ".webm", ".mp3", ".wav"} {
+		if strings.HasSuffix(strings.ToLower(filename), e) {
+			ext = e
+			break
 		}
+	}
+
+	var mediaType string
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		mediaType = "image"
+	case ".mp4", ".webm":
+		mediaType = "video"
+	case ".mp3", ".wav":
+		mediaType = "audio"
+	default:
+		return nil, commandError(gateway.ErrorInvalidData, "Unsupported file type")
+	}
 
-		messageResponse := updatedMessage.ToResponse()
-
-		payload, _ := json.Marshal(struct {
-			Type      string                 `json:"type"`
-			ID        uint                   `json:"id"`
-			ChannelID uint                   `json:"channel_id"`
-			SenderID  uint                   `json:"sender"`
-			User      entity.UserResponse    `json:"user"`
-			Content   string                 `json:"content"`
-			Timestamp time.Time              `json:"timestamp"`
-			Medias    []entity.MediaResponse `json:"medias"`
-		}{
-			Type:      "MESSAGE",
-			ID:        messageResponse.ID,
-			ChannelID: messageResponse.ChannelID,
-			SenderID:  messageResponse.User.ID,
-			User:      messageResponse.User,
-			Content:   messageResponse.Content,
-			Timestamp: messageResponse.CreatedAt,
-			Medias:    messageResponse.Medias,
-		})
+	return &mediaUpload{
+		Filename:  filename,
+		Content:   decoded,
+		Type:      mediaType,
+		Extension: ext[1:],
+	}, nil
+}
 
-		broadcastToChannel(db, message.ChannelID, payload)
+// write saves the file to the uploads and returns the media to attach to the message
+func (upload *mediaUpload) write() (*entity.Media, error) {
+	uploadDir := "uploads/messages"
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), upload.Filename)
+	filePath := filepath.Join(uploadDir, filename)
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(upload.Content); err != nil {
+		os.Remove(filePath)
+		return nil, err
+	}
 
-	case "JOIN_CHANNEL":
-		channelService := services.NewChannelService(db)
+	return &entity.Media{
+		Type:      upload.Type,
+		Extension: upload.Extension,
+		Url:       filePath,
+	}, nil
+}
+
+func (c *Client) joinChannel(db *gorm.DB, cmd *command) error {
+	var data gateway.ChannelSubscription
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
+
+	channelService := services.NewChannelService(db)
+	channel, err := channelService.GetChannel(data.ChannelID)
+	if err != nil {
+		return lookupError(err, "Unknown channel %d", data.ChannelID)
+	}
 
-		channel, err := channelService.GetChannel(incoming.ChannelID)
+	allowed, err := services.NewPermissionService(db).CanAccessChannel(channel.ID, c.UserID)
+	if err := permissionError(allowed, err, "Not allowed to join channel %d", channel.ID); err != nil {
+		return err
+	}
+	// Direct messages are delivered to their recipients without joining
+	if channel.IsDirect() {
+		recipientIDs, err := channelService.GetChannelRecipientIDs(channel.ID)
 		if err != nil {
-			log.Println("Failed to find channel:", err)
-			return
+			return err
 		}
-		c.joinChannel(channel.Name)
+		c.joinDirectChannel(channel.ID, recipientIDs)
+		return nil
+	}
+	hub.JoinChannel(c, channel.ID)
+	log.Printf("User %d joined channel %d", c.UserID, channel.ID)
+	return nil
+}
 
-	case "REACTION":
-		if incoming.MessageID == 0 || incoming.Reaction == "" {
-			log.Println("Invalid reaction data")
-			return
-		}
+func (c *Client) leaveChannel(cmd *command) error {
+	var data gateway.ChannelSubscription
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
 
-		messageService := services.NewMessageService(db)
+	hub.LeaveChannel(c, data.ChannelID)
+	StopTyping(c.UserID, data.ChannelID)
+	log.Printf("User %d left channel %d", c.UserID, data.ChannelID)
+	return nil
+}
 
-		// Create a new reaction
-		reaction := entity.Reaction{
-			UserID:    c.UserID,
-			MessageID: incoming.MessageID,
-			Emoji:     incoming.Reaction,
-		}
+func (c *Client) joinThread(db *gorm.DB, cmd *command) error {
+	var data gateway.ThreadSubscription
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
 
-		// Add the reaction to the database
-		if err := messageService.AddReaction(&reaction); err != nil {
-			log.Println("Failed to add reaction:", err)
-			return
+	thread, err := services.NewThreadService(db).GetThread(data.ThreadID)
+	if err != nil {
+		return lookupError(err, "Unknown thread %d", data.ThreadID)
+	}
+
+	allowed, err := services.NewPermissionService(db).CanAccessChannel(thread.ChannelID, c.UserID)
+	if err := permissionError(allowed, err, "Not allowed to join thread %d", thread.ID); err != nil {
+		return err
+	}
+	hub.JoinThread(c, thread.ID)
+	log.Printf("User %d joined thread %d", c.UserID, thread.ID)
+	return nil
+}
+
+func (c *Client) addReaction(db *gorm.DB, cmd *command) error {
+	var data gateway.Reaction
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
+	if data.MessageID == 0 || data.Reaction == "" {
+		return commandError(gateway.ErrorInvalidData, "Invalid reaction data")
+	}
+
+	messageService := services.NewMessageService(db)
+
+	target, err := messageService.GetMessage(fmt.Sprintf("%d", data.MessageID))
+	if err != nil {
+		return lookupError(err, "Unknown message %d", data.MessageID)
+	}
+
+	allowed, err := services.NewPermissionService(db).CanAccessChannel(target.ChannelID, c.UserID)
+	if err := permissionError(allowed, err, "Not allowed to react in channel %d", target.ChannelID); err != nil {
+		return err
+	}
+
+	blocked, err := services.NewFriendshipService(db).IsBlocked(target.UserID, c.UserID)
+	if err := permissionError(!blocked, err, "Not allowed to react to message %d", target.ID); err != nil {
+		return err
+	}
+
+	// Create a new reaction
+	reaction := entity.Reaction{
+		UserID:    c.UserID,
+		MessageID: data.MessageID,
+		Emoji:     data.Reaction,
+	}
+
+	// Add the reaction to the database
+	if err := messageService.AddReaction(&reaction); err != nil {
+		return err
+	}
+
+	// Get the updated message with the new reaction
+	messageId := fmt.Sprintf("%d", data.MessageID)
+	message, err := messageService.GetMessage(messageId)
+	if err != nil {
+		return err
+	}
+
+	// Convert to response format
+	messageResponse := message.ToResponse()
+
+	// Broadcast the updated message to all clients in the channel
+	payload, _ := json.Marshal(struct {
+		Type      string                    `json:"type"`
+		ID        uint                      `json:"id"`
+		ChannelID uint                      `json:"channel_id"`
+		SenderID  uint                      `json:"sender"`
+		User      entity.UserResponse       `json:"user"`
+		Content   string                    `json:"content"`
+		Timestamp time.Time                 `json:"timestamp"`
+		Medias    []entity.MediaResponse    `json:"medias"`
+		Reactions []entity.ReactionResponse `json:"reactions"`
+	}{
+		Type:      "MESSAGE_UPDATE",
+		ID:        messageResponse.ID,
+		ChannelID: messageResponse.ChannelID,
+		SenderID:  messageResponse.User.ID,
+		User:      messageResponse.User,
+		Content:   messageResponse.Content,
+		Timestamp: messageResponse.CreatedAt,
+		Medias:    messageResponse.Medias,
+		Reactions: messageResponse.Reactions,
+	})
+
+	broadcastToChannel(db, messageResponse.ChannelID, payload)
+	return nil
+}
+
+func (c *Client) editMessage(db *gorm.DB, cmd *command) error {
+	var data gateway.MessageEdit
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
+	if data.MessageID == 0 || strings.TrimSpace(data.Content) == "" {
+		return commandError(gateway.ErrorInvalidData, "Invalid edit data")
+	}
+
+	messageService := services.NewMessageService(db)
+	message, err := messageService.GetMessage(fmt.Sprintf("%d", data.MessageID))
+	if err != nil {
+		return lookupError(err, "Unknown message %d", data.MessageID)
+	}
+
+	if message.UserID != c.UserID {
+		return commandError(gateway.ErrorForbidden, "Not allowed to edit message %d", message.ID)
+	}
+
+	allowed, err := services.NewPermissionService(db).CanAccessChannel(message.ChannelID, c.UserID)
+	if err := permissionError(allowed, err, "Not allowed to access channel %d", message.ChannelID); err != nil {
+		return err
+	}
+
+	if err := messageService.EditMessage(message, data.Content); err != nil {
+		return err
+	}
+
+	BroadcastMessageEdit(db, message)
+	return nil
+}
+
+func (c *Client) deleteMessage(db *gorm.DB, cmd *command) error {
+	var data gateway.MessageDelete
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
+	if data.MessageID == 0 {
+		return commandError(gateway.ErrorInvalidData, "Invalid delete data")
+	}
+
+	messageService := services.NewMessageService(db)
+	message, err := messageService.GetMessage(fmt.Sprintf("%d", data.MessageID))
+	if err != nil {
+		return lookupError(err, "Unknown message %d", data.MessageID)
+	}
+
+	allowed, err := services.NewPermissionService(db).CanDeleteMessage(message, c.UserID)
+	if err := permissionError(allowed, err, "Not allowed to delete message %d", message.ID); err != nil {
+		return err
+	}
+
+	if err := messageService.DeleteMessage(message); err != nil {
+		return err
+	}
+
+	// Deleting someone else's message is a moderation action
+	if message.UserID != c.UserID {
+		channel, err := services.NewChannelService(db).GetChannel(message.ChannelID)
+		if err == nil && !channel.IsDirect() {
+			services.NewAuditLogService(db).Record(&entity.AuditLogEntry{
+				ServerID:   *channel.ServerID,
+				ActorID:    c.UserID,
+				Action:     entity.AuditMessageDelete,
+				TargetType: entity.AuditTargetMessage,
+				TargetID:   message.ID,
+				Changes:    entity.AuditLogChanges{"content": {Old: message.Content}},
+			})
 		}
+	}
+
+	BroadcastMessageDelete(db, message)
+	return nil
+}
 
-		// Get the updated message with the new reaction
-		messageId := fmt.Sprintf("%d", incoming.MessageID)
-		message, err := messageService.GetMessage(messageId)
+func (c *Client) markRead(db *gorm.DB, cmd *command) (*gateway.Ack, error) {
+	var data gateway.MarkRead
+	if err := cmd.decode(&data); err != nil {
+		return nil, err
+	}
+
+	allowed, err := services.NewPermissionService(db).CanAccessChannel(data.ChannelID, c.UserID)
+	if err := permissionError(allowed, err, "Not allowed to access channel %d", data.ChannelID); err != nil {
+		return nil, err
+	}
+
+	readStateService := services.NewReadStateService(db)
+	messageID, err := readStateService.AckChannel(c.UserID, data.ChannelID, data.MessageID)
+	if err == services.ErrMessageNotInChannel {
+		return nil, commandError(gateway.ErrorInvalidData, "%v", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	NotifyReadState(c.UserID, data.ChannelID, messageID)
+	return &gateway.Ack{MessageID: messageID}, nil
+}
+
+func (c *Client) changePresence(db *gorm.DB, cmd *command) error {
+	var data gateway.PresenceUpdate
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
+
+	if data.Status != "" {
+		presenceService := services.NewPresenceService(db)
+		err := presenceService.SetStatus(c.UserID, data.Status)
+		if err == services.ErrInvalidStatus {
+			return commandError(gateway.ErrorInvalidData, "%v", err)
+		}
 		if err != nil {
-			log.Println("Failed to get updated message:", err)
-			return
+			return err
 		}
+		setUserStatus(c.UserID, data.Status)
+		NotifyStatus(c.UserID, data.Status)
+	}
+	if data.Idle != nil {
+		c.setIdle(*data.Idle)
+	}
+
+	updatePresence(db, c.UserID)
+	return nil
+}
+
+func (c *Client) sendTyping(cmd *command) error {
+	var data gateway.ChannelSubscription
+	if err := cmd.decode(&data); err != nil {
+		return err
+	}
 
-		// Convert to response format
-		messageResponse := message.ToResponse()
-
-		// Broadcast the updated message to all clients in the channel
-		payload, _ := json.Marshal(struct {
-			Type      string                    `json:"type"`
-			ID        uint                      `json:"id"`
-			ChannelID uint                      `json:"channel_id"`
-			SenderID  uint                      `json:"sender"`
-			User      entity.UserResponse       `json:"user"`
-			Content   string                    `json:"content"`
-			Timestamp time.Time                 `json:"timestamp"`
-			Medias    []entity.MediaResponse    `json:"medias"`
-			Reactions []entity.ReactionResponse `json:"reactions"`
-		}{
-			Type:      "MESSAGE_UPDATE",
-			ID:        messageResponse.ID,
-			ChannelID: messageResponse.ChannelID,
-			SenderID:  messageResponse.User.ID,
-			User:      messageResponse.User,
-			Content:   messageResponse.Content,
-			Timestamp: messageResponse.CreatedAt,
-			Medias:    messageResponse.Medias,
-			Reactions: messageResponse.Reactions,
-		})
-
-		broadcastToChannel(db, messageResponse.ChannelID, payload)
+	// Typing indicators are only kept in memory, clients must have joined the channel first
+	send := c.typingAudience(data.ChannelID)
+	if send == nil {
+		return commandError(gateway.ErrorNotSubscribed, "Channel %d was not joined", data.ChannelID)
 	}
+	startTyping(c.UserID, data.ChannelID, send)
+	return nil
+}
+
+// BroadcastMessageEdit sends the new content of a message to the subscribers of its channel
+func BroadcastMessageEdit(db *gorm.DB, message *entity.Message) {
+	messageResponse := message.ToResponse()
+
+	payload, _ := json.Marshal(struct {
+		Type      string              `json:"type"`
+		ID        uint                `json:"id"`
+		ChannelID uint                `json:"channel_id"`
+		SenderID  uint                `json:"sender"`
+		User      entity.UserResponse `json:"user"`
+		Content   string              `json:"content"`
+		Timestamp time.Time           `json:"timestamp"`
+		EditedAt  *time.Time          `json:"edited_at"`
+		Revisions int                 `json:"revision_count"`
+	}{
+		Type:      "MESSAGE_EDIT",
+		ID:        messageResponse.ID,
+		ChannelID: messageResponse.ChannelID,
+		SenderID:  messageResponse.User.ID,
+		User:      messageResponse.User,
+		Content:   messageResponse.Content,
+		Timestamp: messageResponse.CreatedAt,
+		EditedAt:  messageResponse.EditedAt,
+		Revisions: messageResponse.RevisionCount,
+	})
+
+	broadcastToChannel(db, message.ChannelID, payload)
+}
+
+// BroadcastMessageDelete tells the subscribers of a channel that a message was deleted
+func BroadcastMessageDelete(db *gorm.DB, message *entity.Message) {
+	payload, _ := json.Marshal(struct {
+		Type      string `json:"type"`
+		ID        uint   `json:"id"`
+		ChannelID uint   `json:"channel_id"`
+	}{
+		Type:      "MESSAGE_DELETE",
+		ID:        message.ID,
+		ChannelID: message.ChannelID,
+	})
+
+	broadcastToChannel(db, message.ChannelID, payload)
+}
+
+// BroadcastThread sends a thread event such as THREAD_CREATE to the subscribers of its parent channel
+func BroadcastThread(db *gorm.DB, eventType string, thread *entity.Thread) {
+	payload, _ := json.Marshal(struct {
+		Type   string                `json:"type"`
+		Thread entity.ThreadResponse `json:"thread"`
+	}{
+		Type:   eventType,
+		Thread: thread.ToResponse(),
+	})
+
+	broadcastToChannel(db, thread.ChannelID, payload)
+}
+
+// BroadcastChannelPinsUpdate tells the subscribers of a channel that a message was pinned or unpinned
+func BroadcastChannelPinsUpdate(db *gorm.DB, message *entity.Message) {
+	payload, _ := json.Marshal(struct {
+		Type      string     `json:"type"`
+		ChannelID uint       `json:"channel_id"`
+		MessageID uint       `json:"message_id"`
+		Pinned    bool       `json:"pinned"`
+		PinnedAt  *time.Time `json:"pinned_at"`
+		PinnedBy  *uint      `json:"pinned_by"`
+	}{
+		Type:      "CHANNEL_PINS_UPDATE",
+		ChannelID: message.ChannelID,
+		MessageID: message.ID,
+		Pinned:    message.Pinned,
+		PinnedAt:  message.PinnedAt,
+		PinnedBy:  message.PinnedByID,
+	})
+
+	broadcastToChannel(db, message.ChannelID, payload)
+}
+
+func broadcastToThread(threadID uint, message []byte) {
+	hub.BroadcastToThread(threadID, message)
 }
 
 func broadcastToChannel(db *gorm.DB, channelId uint, message []byte) {
@@ -316,12 +783,17 @@ func broadcastToChannel(db *gorm.DB, channelId uint, message []byte) {
 		return
 	}
 
-	clients := ChannelClients[channel.Name]
-	for client := range clients {
-		select {
-		case client.Send <- message:
-		default:
-			log.Printf("Client %d buffer full", client.UserID)
+	if channel.IsDirect() {
+		recipientIDs, err := channelService.GetChannelRecipientIDs(channel.ID)
+		if err != nil {
+			log.Println("Failed to get channel recipients:", err)
+			return
 		}
+		for _, recipientID := range recipientIDs {
+			SendToUser(recipientID, message)
+		}
+		return
 	}
+
+	hub.BroadcastToChannel(channel.ID, message)
 }
@@ -0,0 +1,153 @@
+// Package gateway describes the frames exchanged over the WebSocket gateway.
+// Clients pick the protocol version with the v query parameter when connecting:
+// version 1 sends flat JSON objects with a type field, version 2 wraps every
+// frame in an Envelope.
+package gateway
+
+import "encoding/json"
+
+const (
+	// Version1 is the original protocol of flat frames, used when no version is given
+	Version1 = 1
+	// Version2 wraps frames in an Envelope and answers every command with an ACK or ERROR frame
+	Version2 = 2
+
+	DefaultVersion = Version1
+	LatestVersion  = Version2
+)
+
+// IsSupportedVersion reports whether the gateway speaks the protocol version
+func IsSupportedVersion(version int) bool {
+	return version >= Version1 && version <= LatestVersion
+}
+
+// Op tells what an Envelope carries
+type Op int
+
+const (
+	// OpDispatch is an event sent by the server, named by Type and numbered by Seq
+	OpDispatch Op = 0
+	// OpCommand is a request sent by the client, named by Type
+	OpCommand Op = 1
+	// OpHello is the first frame sent on a connection
+	OpHello Op = 2
+	// OpAck tells the client that the command with the same nonce succeeded
+	OpAck Op = 3
+	// OpError tells the client that the command with the same nonce failed
+	OpError Op = 4
+)
+
+// Envelope is a version 2 frame. The nonce of a command is copied into its ACK or ERROR frame.
+type Envelope struct {
+	Op    Op              `json:"op"`
+	Type  string          `json:"type,omitempty"`
+	Nonce string          `json:"nonce,omitempty"`
+	Seq   uint64          `json:"seq,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Command types sent by clients
+const (
+	TypeResume         = "RESUME"
+	TypeMessage        = "MESSAGE"
+	TypeJoinChannel    = "JOIN_CHANNEL"
+	TypeLeaveChannel   = "LEAVE_CHANNEL"
+	TypeJoinThread     = "JOIN_THREAD"
+	TypeReaction       = "REACTION"
+	TypeMessageEdit    = "MESSAGE_EDIT"
+	TypeMessageDelete  = "MESSAGE_DELETE"
+	TypeMarkRead       = "MARK_READ"
+	TypePresenceUpdate = "PRESENCE_UPDATE"
+	TypeTypingStart    = "TYPING_START"
+)
+
+// ErrorCode is the machine readable reason of an ERROR frame
+type ErrorCode string
+
+const (
+	ErrorInvalidFrame   ErrorCode = "INVALID_FRAME"
+	ErrorUnknownType    ErrorCode = "UNKNOWN_TYPE"
+	ErrorInvalidData    ErrorCode = "INVALID_DATA"
+	ErrorNotFound       ErrorCode = "NOT_FOUND"
+	ErrorForbidden      ErrorCode = "FORBIDDEN"
+	ErrorNotSubscribed  ErrorCode = "NOT_SUBSCRIBED"
+	ErrorInvalidSession ErrorCode = "INVALID_SESSION"
+	ErrorInternal       ErrorCode = "INTERNAL_ERROR"
+)
+
+// Hello is the data of the OpHello frame
+type Hello struct {
+	SessionID         string `json:"session_id"`
+	HeartbeatInterval int64  `json:"heartbeat_interval"` // In milliseconds
+	Version           int    `json:"version"`
+}
+
+// Ack is the data of an OpAck frame, MessageID is set when the command created or read a message
+type Ack struct {
+	MessageID uint `json:"message_id,omitempty"`
+}
+
+// Error is the data of an OpError frame
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// Resume resumes a closed session, Seq is the last sequence number the client received
+type Resume struct {
+	SessionID string `json:"session_id"`
+	Seq       uint64 `json:"seq"`
+}
+
+// Message posts a message in a channel or thread, File is a base64 data URL named Filename
+type Message struct {
+	ChannelID           uint   `json:"channel_id"`
+	ThreadID            uint   `json:"thread_id,omitempty"`
+	ReferencedMessageID uint   `json:"referenced_message_id,omitempty"`
+	Content             string `json:"content"`
+	File                string `json:"file,omitempty"`
+	Filename            string `json:"filename,omitempty"`
+}
+
+// ChannelSubscription is the data of JOIN_CHANNEL, LEAVE_CHANNEL and TYPING_START
+type ChannelSubscription struct {
+	ChannelID uint `json:"channel_id"`
+}
+
+// ThreadSubscription is the data of JOIN_THREAD
+type ThreadSubscription struct {
+	ThreadID uint `json:"thread_id"`
+}
+
+// Reaction adds an emoji reaction to a message
+type Reaction struct {
+	MessageID uint   `json:"message_id"`
+	Reaction  string `json:"reaction"`
+}
+
+// MessageEdit replaces the content of one of your messages
+type MessageEdit struct {
+	MessageID uint   `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// MessageDelete deletes a message
+type MessageDelete struct {
+	MessageID uint `json:"message_id"`
+}
+
+// MarkRead marks a channel as read up to MessageID, or up to its last message
+type MarkRead struct {
+	ChannelID uint `json:"channel_id"`
+	MessageID uint `json:"message_id,omitempty"`
+}
+
+// PresenceUpdate sets the status of the user or whether this connection is idle
+type PresenceUpdate struct {
+	Status string `json:"status,omitempty"`
+	Idle   *bool  `json:"idle,omitempty"`
+}